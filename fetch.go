@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elliotchance/wikitranslate/wikitext"
+)
+
+// wikiPage is the latest revision of a page that was downloaded from a wiki.
+type wikiPage struct {
+	// Title is the title of the page after it has been normalized and any
+	// redirect has been followed. It uses spaces rather than underscores.
+	Title string
+
+	// Language is the language code of the wiki, like "en". It is empty if
+	// the wiki did not say.
+	Language string
+
+	RevisionID int64
+	Timestamp  time.Time
+	Wikitext   string
+}
+
+// fileName is the title of the page with underscores in place of the spaces,
+// like it is in a URL.
+func (p *wikiPage) fileName() string {
+	return strings.Replace(p.Title, " ", "_", -1)
+}
+
+// apiResponse is the part of a response from the MediaWiki Action API (with
+// formatversion=2) that is needed to read the latest revision of a page.
+type apiResponse struct {
+	Error *struct {
+		Code string `json:"code"`
+		Info string `json:"info"`
+	} `json:"error"`
+
+	Query struct {
+		General struct {
+			Lang string `json:"lang"`
+		} `json:"general"`
+
+		Pages []struct {
+			Title         string `json:"title"`
+			Missing       bool   `json:"missing"`
+			Invalid       bool   `json:"invalid"`
+			InvalidReason string `json:"invalidreason"`
+			Revisions     []struct {
+				RevisionID int64     `json:"revid"`
+				Timestamp  time.Time `json:"timestamp"`
+				Slots      struct {
+					Main struct {
+						Content string `json:"content"`
+					} `json:"main"`
+				} `json:"slots"`
+			} `json:"revisions"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+// apiURL finds the api.php of the wiki and the title of the page from the
+// URL of the page. The URL can be for an article (/wiki/Title) or for
+// index.php with a title parameter.
+func apiURL(pageURL string) (api, title string, err error) {
+	u, err := url.Parse(pageURL)
+	if err != nil || u.Host == "" {
+		return "", "", &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: pageURL,
+			Cause: errors.New("not the URL of a page")}
+	}
+
+	title = u.Query().Get("title")
+	if i := strings.Index(u.Path, "/wiki/"); title == "" && i >= 0 {
+		title = u.Path[i+len("/wiki/"):]
+	}
+	if title == "" {
+		return "", "", &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: pageURL,
+			Cause: errors.New("the URL does not contain the title of a page")}
+	}
+
+	api = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/w/api.php"}).String()
+
+	return api, strings.TrimSpace(title), nil
+}
+
+// downloadWikiPage fetches the latest revision of the page at a URL through
+// the MediaWiki Action API of the same wiki. Redirects are followed.
+func downloadWikiPage(pageURL string) (*wikiPage, error) {
+	api, title, err := apiURL(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"action":        {"query"},
+		"prop":          {"revisions"},
+		"meta":          {"siteinfo"},
+		"siprop":        {"general"},
+		"rvprop":        {"ids|timestamp|content"},
+		"rvslots":       {"main"},
+		"titles":        {title},
+		"redirects":     {"1"},
+		"format":        {"json"},
+		"formatversion": {"2"},
+	}
+	requestURL := api + "?" + query.Encode()
+
+	content, err := downloadURL(requestURL)
+	if err != nil {
+		return nil, err
+	}
+
+	response := apiResponse{}
+	if err := json.Unmarshal(content.Bytes(), &response); err != nil {
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: requestURL, Cause: err}
+	}
+
+	if response.Error != nil {
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: requestURL,
+			Cause: fmt.Errorf("%v: %v", response.Error.Code, response.Error.Info)}
+	}
+
+	if len(response.Query.Pages) == 0 {
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: requestURL,
+			Cause: errors.New("the response does not contain a page")}
+	}
+
+	page := response.Query.Pages[0]
+	switch {
+	case page.Invalid:
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: title,
+			Cause: fmt.Errorf("invalid title: %v", page.InvalidReason)}
+
+	case page.Missing:
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: page.Title,
+			Cause: errors.New("the page does not exist")}
+
+	case len(page.Revisions) == 0:
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: page.Title,
+			Cause: errors.New("the page does not have any revisions")}
+	}
+
+	revision := page.Revisions[0]
+
+	return &wikiPage{
+		Title:      page.Title,
+		Language:   response.Query.General.Lang,
+		RevisionID: revision.RevisionID,
+		Timestamp:  revision.Timestamp,
+		Wikitext:   revision.Slots.Main.Content,
+	}, nil
+}
+
+// printRevision shows which revision of the page was downloaded.
+func printRevision(page *wikiPage) {
+	fmt.Printf("Revision %d of %v (%v)\n", page.RevisionID, page.Title, page.Timestamp.Format(time.RFC3339))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elliotchance/wikitranslate/wikitext"
+)
+
+// apiResponses are the responses of the test wiki for each title.
+var apiResponses = map[string]string{
+	"Staffordshire_Bull_Terrier": `{"batchcomplete":true,"query":{"general":{"sitename":"Wikipedia","lang":"en"},"normalized":[{"fromencoded":false,"from":"Staffordshire_Bull_Terrier","to":"Staffordshire Bull Terrier"}],` +
+		`"pages":[{"pageid":27411,"ns":0,"title":"Staffordshire Bull Terrier","revisions":[{"revid":1001,"parentid":1000,"timestamp":"2020-05-01T10:20:30Z",` +
+		`"slots":{"main":{"contentmodel":"wikitext","contentformat":"text/x-wiki","content":"The '''Staffordshire Bull Terrier''' is a [[dog]] & more."}}}]}]}}`,
+	"staffy": `{"batchcomplete":true,"query":{"normalized":[{"fromencoded":false,"from":"staffy","to":"Staffy"}],` +
+		`"redirects":[{"from":"Staffy","to":"Staffordshire Bull Terrier"}],` +
+		`"pages":[{"pageid":27411,"ns":0,"title":"Staffordshire Bull Terrier","revisions":[{"revid":1001,"parentid":1000,"timestamp":"2020-05-01T10:20:30Z",` +
+		`"slots":{"main":{"contentmodel":"wikitext","contentformat":"text/x-wiki","content":"Foo"}}}]}]}}`,
+	"Missing": `{"batchcomplete":true,"query":{"pages":[{"ns":0,"title":"Missing","missing":true}]}}`,
+	"<":       `{"batchcomplete":true,"query":{"pages":[{"title":"<","invalidreason":"The requested page title contains invalid characters: \"<\".","invalid":true}]}}`,
+	"Error":   `{"error":{"code":"badvalue","info":"Unrecognized value for parameter \"action\"."}}`,
+	"Garbage": `<!DOCTYPE html><html></html>`,
+	"NoRevs":  `{"batchcomplete":true,"query":{"pages":[{"pageid":1,"ns":0,"title":"NoRevs"}]}}`,
+	"NoPages": `{"batchcomplete":true}`,
+	"Protected": `{"batchcomplete":true,"query":{"pages":[{"pageid":2,"ns":0,"title":"Protected","revisions":[{"revid":7,"timestamp":"2021-01-02T03:04:05Z",` +
+		`"slots":{"main":{"content":"{{pp-protected}}\nText"}}}]}]}}`,
+}
+
+func newTestWiki(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/w/api.php" || query.Get("action") != "query" || query.Get("prop") != "revisions" ||
+			query.Get("meta") != "siteinfo" || query.Get("siprop") != "general" ||
+			query.Get("rvslots") != "main" || query.Get("format") != "json" || query.Get("redirects") == "" {
+			t.Errorf("Unexpected request: %v", r.URL)
+		}
+		if !strings.HasPrefix(r.Header.Get("User-Agent"), "wikitranslate/") {
+			t.Errorf("Expected a User-Agent, got %q", r.Header.Get("User-Agent"))
+		}
+
+		title := query.Get("titles")
+		if title == "Broken" {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(apiResponses[title]))
+	}))
+}
+
+func TestDownloadWikiPage(t *testing.T) {
+	server := newTestWiki(t)
+	defer server.Close()
+
+	for _, test := range []struct {
+		path       string
+		title      string
+		language   string
+		fileName   string
+		revisionID int64
+		timestamp  string
+		wikitext   string
+	}{
+		{"/wiki/Staffordshire_Bull_Terrier", "Staffordshire Bull Terrier", "en", "Staffordshire_Bull_Terrier",
+			1001, "2020-05-01T10:20:30Z", "The '''Staffordshire Bull Terrier''' is a [[dog]] & more."},
+		{"/wiki/staffy", "Staffordshire Bull Terrier", "", "Staffordshire_Bull_Terrier",
+			1001, "2020-05-01T10:20:30Z", "Foo"},
+		{"/w/index.php?title=Protected&action=edit", "Protected", "", "Protected",
+			7, "2021-01-02T03:04:05Z", "{{pp-protected}}\nText"},
+	} {
+		page, err := downloadWikiPage(server.URL + test.path)
+		if err != nil {
+			t.Errorf("%v: %v", test.path, err)
+			continue
+		}
+
+		timestamp, _ := time.Parse(time.RFC3339, test.timestamp)
+		if page.Title != test.title || page.Language != test.language || page.fileName() != test.fileName ||
+			page.RevisionID != test.revisionID ||
+			!page.Timestamp.Equal(timestamp) || page.Wikitext != test.wikitext {
+			t.Errorf("%v: unexpected page %+v", test.path, page)
+		}
+	}
+}
+
+func TestDownloadWikiPageErrors(t *testing.T) {
+	server := newTestWiki(t)
+	defer server.Close()
+
+	for _, test := range []struct {
+		url     string
+		message string
+	}{
+		{server.URL + "/wiki/Missing", "the page does not exist"},
+		{server.URL + "/wiki/%3C", "invalid title"},
+		{server.URL + "/wiki/Error", "badvalue"},
+		{server.URL + "/wiki/Garbage", "invalid character"},
+		{server.URL + "/wiki/NoRevs", "does not have any revisions"},
+		{server.URL + "/wiki/NoPages", "does not contain a page"},
+		{server.URL + "/wiki/Broken", "500 Internal Server Error"},
+		{server.URL + "/about", "does not contain the title"},
+		{"Staffordshire_Bull_Terrier", "not the URL of a page"},
+	} {
+		page, err := downloadWikiPage(test.url)
+		if page != nil || !errors.Is(err, wikitext.ErrFetchFailed) {
+			t.Errorf("%v: expected a fetch error, got %v (%v)", test.url, page, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%v: expected %q in %q", test.url, test.message, err)
+		}
+		if exitCode(err) != exitFetchFailed {
+			t.Errorf("%v: expected exit code %d, got %d", test.url, exitFetchFailed, exitCode(err))
+		}
+	}
+}
@@ -3,599 +3,536 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"html"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/user"
-	"regexp"
-	"strconv"
+	"path/filepath"
 	"strings"
+
+	"github.com/elliotchance/wikitranslate/wikitext"
 )
 
-const maxTemplateDepth = 8
+// The exit codes are documented in the README.
+const (
+	exitError        = 1
+	exitLossy        = 2
+	exitInvalidInput = 3
+	exitFetchFailed  = 4
+)
 
-const Version = "0.2.1"
+// errLossy is returned by verify when the page would be changed.
+var errLossy = errors.New("the page will not be the same after it is translated")
 
-func check(err error) {
-	if err != nil {
-		panic(err)
-	}
-}
+var converter = &wikitext.Converter{}
 
-func isAnExternalURL(url string) bool {
-	return strings.Contains(url, "://")
-}
+var templatesFile = flag.String("templates", "",
+	"A file with the template parameters that can be translated.")
 
-func replaceAllStringSubmatchFunc(re *regexp.Regexp, str string, repl func([]string) string) string {
-	result := ""
-	lastIndex := 0
+var translateDisplayTitle = flag.Bool("translate-display-title", false,
+	"Allow the title in {{DISPLAYTITLE:...}} to be translated.")
 
-	for _, v := range re.FindAllSubmatchIndex([]byte(str), -1) {
-		groups := []string{}
-		for i := 0; i < len(v); i += 2 {
-			groups = append(groups, str[v[i]:v[i+1]])
-		}
+var showComments = flag.Bool("show-comments", false,
+	"Show the text of <!-- comments --> to translators as notes.")
 
-		result += str[lastIndex:v[0]] + repl(groups)
-		lastIndex = v[1]
-	}
+var opaqueTags = flag.String("opaque-tags", "",
+	"A comma-separated list of more tags (like <math>) whose content is never translated.")
 
-	return result + str[lastIndex:]
-}
+var categoriesFile = flag.String("categories", "",
+	"A file that maps categories to the categories on the target wiki.")
 
-func BalanceHtmlTags(html string) string {
-	parts := []string{}
-	result := ""
-	split1 := strings.Split(html, "<")
-	for _, p := range split1 {
-		parts = append(parts, strings.Split(p, ">")...)
-	}
-
-	stack := []string{}
-	for i := 0; i < len(parts)-1; i += 2 {
-		result += parts[i]
-
-		if len(parts[i+1]) > 0 && parts[i+1][0] == '/' {
-			for j := len(stack) - 1; j >= 0; j-- {
-				s := stack[j]
-				stack = stack[:len(stack)-1]
-				result += fmt.Sprintf("</%v>", s)
-				if parts[i+1] == ("/" + s) {
-					break
-				}
-			}
-		} else {
-			tagParts := strings.Split(parts[i+1], " ")
-			stack = append(stack, tagParts[0])
-			result += fmt.Sprintf("<%v>", parts[i+1])
-		}
-	}
+var langlinksFile = flag.String("langlinks", "",
+	"A file (TSV or SQL) that maps link targets to the pages on the target wiki.")
 
-	result += parts[len(parts)-1]
+var sourceFromFileName = flag.Bool("source-from-file-name", false,
+	"Link back to the English page named after the file when the file does not say which page it came from.")
 
-	// Anything left on the stack has to be closed.
-	for _, s := range stack {
-		result += fmt.Sprintf("</%v>", s)
-	}
+// options are the conversion options. They are set by loadOptions.
+var options wikitext.Options
 
-	return result
-}
+// loadOptions creates the conversion options from the flags.
+func loadOptions() error {
+	var err error
 
-func processHtmlLinks(html string) string {
-	re := regexp.MustCompile(`<a href="(.*?)">(.*?)</a>`)
-	return replaceAllStringSubmatchFunc(re, html, func(groups []string) string {
-		if groups[1] == groups[2] || groups[2] == "" {
-			if isAnExternalURL(groups[1]) {
-				return fmt.Sprintf(`[%v]`, groups[1])
-			}
-			return fmt.Sprintf(`[[%v]]`, groups[1])
-		}
+	options.Templates = wikitext.DefaultTemplatePolicy()
+	options.TranslateDisplayTitle = *translateDisplayTitle
+	options.ShowComments = *showComments
 
-		if isAnExternalURL(groups[1]) {
-			return fmt.Sprintf(`[%v %v]`, groups[1], groups[2])
+	if *opaqueTags != "" {
+		for _, name := range strings.Split(*opaqueTags, ",") {
+			options.OpaqueTags = append(options.OpaqueTags, strings.TrimSpace(name))
 		}
+	}
 
-		return fmt.Sprintf(`[[%v|%v]]`, groups[1], groups[2])
-	})
-}
-
-func processHtmlRefs(html string) string {
-	re := regexp.MustCompile(`<ref data="(.*?)"(.*?)></ref>`)
-	html = replaceAllStringSubmatchFunc(re, html, func(groups []string) string {
-		if len(groups[1]) == 0 {
-			return fmt.Sprintf(`<ref%v/>`, groups[2])
+	if *categoriesFile != "" {
+		options.Categories, err = loadTitleMap(*categoriesFile)
+		if err != nil {
+			return err
 		}
+	}
 
-		decoded, err := base64.StdEncoding.DecodeString(groups[1])
+	if *langlinksFile != "" {
+		options.Links, err = loadTitleMap(*langlinksFile)
 		if err != nil {
-			panic(err)
+			return err
 		}
+	}
 
-		return fmt.Sprintf(`<ref%v>%v</ref>`, groups[2], string(decoded))
-	})
-
-	return html
-}
-
-func HtmlToWiki(html string) string {
-	re := regexp.MustCompile(`<img src="(.*?)" options="(.*?)" link="(.*?)">(.*?)</img>`)
-	html = replaceAllStringSubmatchFunc(re, html, func(groups []string) string {
-		r := fmt.Sprintf(`[[File:%v`, groups[1])
-		if groups[2] != "" || groups[3] != "" {
-			r += "|" + groups[2]
+	if *templatesFile != "" {
+		file, err := os.Open(*templatesFile)
+		if err != nil {
+			return err
 		}
-		if groups[3] != "" || groups[4] != "" {
-			r += "|" + groups[4]
+		defer file.Close()
 
-			if groups[3] != "" {
-				r += "link=" + groups[3]
-			}
+		if err := options.Templates.Load(file); err != nil {
+			return fmt.Errorf("%v: %w", *templatesFile, err)
 		}
-		return r + "]]"
-	})
-
-	html = processHtmlLinks(html)
-
-	html = strings.Replace(html, "<strong><em>", "'''''", -1)
-	html = strings.Replace(html, "</strong></em>", "'''''", -1)
-
-	html = strings.Replace(html, "<strong>", "'''", -1)
-	html = strings.Replace(html, "</strong>", "'''", -1)
-	html = strings.Replace(html, "<em>", "''", -1)
-	html = strings.Replace(html, "</em>", "''", -1)
+	}
 
-	html = strings.Replace(html, "<li>", "*", -1)
-	html = strings.Replace(html, "</li>", "", -1)
-	html = strings.Replace(html, "<oli>", "#", -1)
-	html = strings.Replace(html, "</oli>", "", -1)
+	return nil
+}
 
-	re = regexp.MustCompile(`<h(.)>(.+?)</h.>`)
-	html = replaceAllStringSubmatchFunc(re, html, func(groups []string) string {
-		level, err := strconv.Atoi(groups[1])
-		if err != nil {
-			panic(err)
-		}
+// loadTitleMap loads a file of titles, such as the category mapping.
+func loadTitleMap(fileName string) (*wikitext.TitleMap, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
-		return strings.Repeat("=", level) + groups[2] + strings.Repeat("=", level)
-	})
+	titles := &wikitext.TitleMap{}
+	if err := titles.Load(file); err != nil {
+		return nil, fmt.Errorf("%v: %w", fileName, err)
+	}
 
-	html = prepareNesting(html, "<template", "/template>")
+	return titles, nil
+}
 
-	for templateDepth := maxTemplateDepth; templateDepth >= 0; templateDepth-- {
-		re = regexp.MustCompile(fmt.Sprintf(`<template%v name="(.+?)">(.*?)<%v/template>`, templateDepth, templateDepth))
-		html = replaceAllStringSubmatchFunc(re, html, func(groups []string) string {
-			if groups[2] == "" {
-				return fmt.Sprintf(`{{%v}}`, groups[1])
-			}
+func downloadURL(url string) (*bytes.Buffer, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: url, Cause: err}
+	}
 
-			re = regexp.MustCompile(`<arg name="(.*?)">(.*?)</arg>`)
-			result := replaceAllStringSubmatchFunc(re, groups[2], func(groups []string) string {
-				if groups[1] == "" {
-					return fmt.Sprintf(`|%v`, groups[2])
-				}
+	// Wikimedia asks for every client to identify itself.
+	request.Header.Set("User-Agent", fmt.Sprintf(
+		"wikitranslate/%v (https://github.com/elliotchance/wikitranslate)", wikitext.Version))
 
-				return fmt.Sprintf(`|%v=%v`, groups[1], groups[2])
-			})
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: url, Cause: err}
+	}
+	defer response.Body.Close()
 
-			return fmt.Sprintf(`{{%v%v}}`, groups[1], result)
-		})
+	if response.StatusCode != http.StatusOK {
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: url,
+			Cause: fmt.Errorf("server responded with %v", response.Status)}
 	}
 
-	re = regexp.MustCompile(`(?s)<table(.*?)>(.*?)</table>`)
-	html = replaceAllStringSubmatchFunc(re, html, func(groups []string) string {
-		result := "{|"
+	buf := new(bytes.Buffer)
+	if _, err = buf.ReadFrom(response.Body); err != nil {
+		return nil, &wikitext.Error{Err: wikitext.ErrFetchFailed, Element: url, Cause: err}
+	}
 
-		re = regexp.MustCompile(`(?s)<tr(.*?)>\n(.*?)\n</tr>`)
-		result += replaceAllStringSubmatchFunc(re, groups[2], func(groups []string) string {
-			re = regexp.MustCompile(`(?s)<t([dh])(.*?)>(.*?)</t[dh]>`)
-			return "|-" + strings.TrimSpace(groups[1]) + "\n" + replaceAllStringSubmatchFunc(re, groups[2], func(groups []string) string {
-				if groups[1] == "d" {
-					return "|" + groups[3]
-				}
-				return "!" + groups[3]
-			})
-		})
+	return buf, nil
+}
 
-		return result + "|}"
-	})
+func createOrReplaceFileWithString(fileName, content string) error {
+	return createOrReplaceFileWithBytes(fileName, []byte(content))
+}
 
-	html = processHtmlRefs(html)
+func createOrReplaceFileWithBytes(fileName string, content []byte) error {
+	fileHandle, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
 
-	re = regexp.MustCompile(`<nowiki data="(.*?)"(.*?)></nowiki>`)
-	html = replaceAllStringSubmatchFunc(re, html, func(groups []string) string {
-		decoded, err := base64.StdEncoding.DecodeString(groups[1])
-		if err != nil {
-			panic(err)
-		}
+	writer := bufio.NewWriter(fileHandle)
+	defer fileHandle.Close()
 
-		return fmt.Sprintf(`<nowiki%v>%v</nowiki>`, groups[2], string(decoded))
-	})
+	writer.Write(content)
 
-	return html
+	return writer.Flush()
 }
 
-func prepareNesting(s, left, right string) string {
-	re := regexp.MustCompile(fmt.Sprintf("(%v|%v)", left, right))
-	depth := 0
-	s = replaceAllStringSubmatchFunc(re, s, func(groups []string) string {
-		if groups[1] == left {
-			r := left + strconv.Itoa(depth)
-			depth += 1
-			return r
-		}
-
-		depth -= 1
-		return strconv.Itoa(depth) + right
-	})
+// downloadsPath returns the path for a new file in the Downloads folder.
+func downloadsPath(fileName string) (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
 
-	return s
+	return usr.HomeDir + "/Downloads/" + fileName, nil
 }
 
-func processTemplates(wikimarkup string) string {
-	wikimarkup = prepareNesting(wikimarkup, "{{", "}}")
-
-	substitutions := []string{}
-
-	for templateDepth := maxTemplateDepth; templateDepth >= 0; templateDepth-- {
-		re := regexp.MustCompile(fmt.Sprintf("(?s){{%d([^|}]+)\\|?(.*?)%d}}", templateDepth, templateDepth))
-		wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-			r := `<template name="` + strings.TrimSpace(groups[1]) + `">`
-
-			if groups[2] != "" {
-				params := strings.Split(groups[2], "|")
-				for _, param := range params {
-					if result, _ := regexp.MatchString("^[\\w\\s]+=", param); result {
-						kv := strings.SplitN(param, "=", 2)
-						r += fmt.Sprintf(`<arg name="%v">%v</arg>`, strings.TrimSpace(kv[0]), kv[1])
-					} else {
-						r += fmt.Sprintf(`<arg name="">%v</arg>`, param)
-					}
-				}
-			}
-
-			substitutions = append(substitutions, r+"</template>")
+// readWikiPage returns the page at a URL or the wikitext in a file. The title
+// and language of a file are not known.
+func readWikiPage(input string) (*wikiPage, error) {
+	if strings.HasPrefix(input, "http") {
+		fmt.Printf("Downloading page... ")
+		page, err := downloadWikiPage(input)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf(" Done\n")
+		printRevision(page)
 
-			return fmt.Sprintf("~~%d~~", len(substitutions)-1)
-		})
+		return page, nil
 	}
 
-	// Run the substitutions
-	for {
-		madeChange := false
-
-		for i, sub := range substitutions {
-			newMarkup := strings.Replace(wikimarkup, fmt.Sprintf("~~%d~~", i), sub, -1)
-			if newMarkup != wikimarkup {
-				wikimarkup = newMarkup
-				madeChange = true
-				break
-			}
-		}
-
-		if !madeChange {
-			break
-		}
+	content, err := ioutil.ReadFile(input)
+	if err != nil {
+		return nil, err
 	}
 
-	return wikimarkup
+	return &wikiPage{Wikitext: string(content)}, nil
 }
 
-func processWikiLinks(wikimarkup string) string {
-	re := regexp.MustCompile("\\[\\[(.+?)\\]\\]")
-	wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-		// File:
-		if strings.HasPrefix(groups[1], "File:") {
-			parts := strings.SplitN(groups[1], "|", 3)
-			if len(parts) == 1 {
-				parts = append(parts, "", "")
-			} else if len(parts) == 2 {
-				parts = append(parts, "")
-			}
+// pageOptions returns the options for exporting a page. The page is recorded
+// in the exported file.
+func pageOptions(page *wikiPage) wikitext.Options {
+	pageOptions := options
+	pageOptions.SourceLanguage = page.Language
+	pageOptions.SourceTitle = page.Title
 
-			link := ""
-			if strings.HasPrefix(parts[2], "link=") {
-				link = parts[2][5:]
-				parts[2] = ""
-			}
+	return pageOptions
+}
 
-			return fmt.Sprintf(`<img src="%v" options="%v" link="%v">%v</a>`,
-				parts[0][5:], parts[1], link, parts[2])
-		}
+// exportPage downloads a page and saves it to the Downloads folder in the
+// format written by convert.
+func exportPage(pageURL, extension string, convert func(page *wikiPage, w io.Writer) error) error {
+	fmt.Printf("Downloading page... ")
 
-		// Else
-		parts := strings.SplitN(groups[1], "|", 2)
-		if len(parts) == 1 {
-			if isAnExternalURL(parts[0]) {
-				parts = append(parts, "")
-			} else {
-				parts = append(parts, parts[0])
-			}
-		}
+	page, err := downloadWikiPage(pageURL)
+	if err != nil {
+		return err
+	}
 
-		return fmt.Sprintf(`<a href="%v">%v</a>`, parts[0], parts[1])
-	})
+	destinationPath, err := downloadsPath(page.fileName() + extension)
+	if err != nil {
+		return err
+	}
 
-	return wikimarkup
-}
+	file, err := os.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-func processWikiRefs(wikimarkup string) string {
-	re := regexp.MustCompile(`<ref([^>]*?)/>`)
-	wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-		return fmt.Sprintf(`<raf data=""%v></ref>`, groups[1])
-	})
+	if err := convert(page, file); err != nil {
+		return err
+	}
+
+	fmt.Printf(" Done\nThe file has been created at: %v\n", destinationPath)
+	printRevision(page)
 
-	re = regexp.MustCompile(`<ref([^>]*?)>(.*?)</ref>`)
-	wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-		encoded := base64.StdEncoding.EncodeToString([]byte(groups[2]))
-		return fmt.Sprintf(`<ref data="%v"%v></ref>`, encoded, groups[1])
-	})
+	return nil
+}
 
-	wikimarkup = strings.Replace(wikimarkup, "<raf", "<ref", -1)
+func exportHtml(page *wikiPage, w io.Writer) error {
+	return converter.ToHTML(strings.NewReader(page.Wikitext), w, pageOptions(page))
+}
 
-	return wikimarkup
+func exportXliff(page *wikiPage, w io.Writer) error {
+	return converter.ToXLIFF(strings.NewReader(page.Wikitext), w, pageOptions(page))
 }
 
-func WikiToHtml(wikimarkup string) string {
-	re := regexp.MustCompile(`<nowiki(.*?)>(.*?)</nowiki>`)
-	wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-		encoded := base64.StdEncoding.EncodeToString([]byte(groups[2]))
-		return fmt.Sprintf(`<nowiki data="%v"%v></nowiki>`, encoded, groups[1])
-	})
+// exportPageXliff12 is like exportPage but also saves the skeleton file that
+// is needed to import the XLIFF 1.2 file.
+func exportPageXliff12(pageURL string) error {
+	fmt.Printf("Downloading page... ")
 
-	wikimarkup = processWikiRefs(wikimarkup)
+	page, err := downloadWikiPage(pageURL)
+	if err != nil {
+		return err
+	}
 
-	re = regexp.MustCompile("'''(.+?)'''")
-	wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-		return "<strong>" + groups[1] + "</strong>"
-	})
+	title := page.fileName()
+	xliff, skeleton := new(bytes.Buffer), new(bytes.Buffer)
+	err = converter.ToXLIFF12(strings.NewReader(page.Wikitext), xliff, skeleton, title, pageOptions(page))
+	if err != nil {
+		return err
+	}
+
+	destinationPath, err := downloadsPath(title + ".xlf")
+	if err != nil {
+		return err
+	}
 
-	re = regexp.MustCompile("''(.+?)''")
-	wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-		return "<em>" + groups[1] + "</em>"
-	})
+	skeletonPath, err := downloadsPath(title + ".skl")
+	if err != nil {
+		return err
+	}
 
-	// The links have to be processed before the templates because the regex
-	// will get confused with the shared pipe.
-	wikimarkup = processWikiLinks(wikimarkup)
+	if err := createOrReplaceFileWithBytes(destinationPath, xliff.Bytes()); err != nil {
+		return err
+	}
 
-	wikimarkup = processTemplates(wikimarkup)
+	if err := createOrReplaceFileWithBytes(skeletonPath, skeleton.Bytes()); err != nil {
+		return err
+	}
 
-	re = regexp.MustCompile("\\[(.{8,}?)\\]")
-	wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-		parts := strings.SplitN(groups[1], " ", 2)
-		if len(parts) == 1 {
-			parts = append(parts, "")
-		}
+	fmt.Printf(" Done\nThe file has been created at: %v\n", destinationPath)
+	printRevision(page)
+	fmt.Printf("Keep the skeleton file in the same folder: %v\n", skeletonPath)
 
-		return fmt.Sprintf(`<a href="%v">%v</a>`, parts[0], parts[1])
-	})
+	return nil
+}
 
-	// Headings
-	for i := 6; i >= 1; i-- {
-		re = regexp.MustCompile("(^|\\s)" + strings.Repeat("=", i) + "(.+?)" + strings.Repeat("=", i))
-		wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-			return fmt.Sprintf("%v<h%v>%v</h%v>", groups[1], i, groups[2], i)
-		})
+// importFile converts a translated file back into wikitext. The format is
+// chosen from the file extension.
+func importFile(fileName string) error {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
 	}
 
-	// Bullet points/lists
-	re = regexp.MustCompile("(?m)^([*#])([^\\n]+)")
-	wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(groups []string) string {
-		if groups[1] == "*" {
-			return fmt.Sprintf("<li>%v</li>", groups[2])
-		}
+	importOptions := fileImportOptions(fileName)
 
-		return fmt.Sprintf("<oli>%v</oli>", groups[2])
-	})
-
-	// Images
-	// Raw URLs
-
-	// Tables
-	re = regexp.MustCompile("(?s){\\|([^\\n]*)(.*?)\\|}")
-	wikimarkup = replaceAllStringSubmatchFunc(re, wikimarkup, func(table_groups []string) string {
-		table := "<table " + table_groups[1] + ">\n"
-
-		lines := strings.Split(table_groups[2], "\n")
-
-		inRow := false
-		printedTr := false
-		for _, line := range lines {
-			if strings.HasPrefix(line, "|-") {
-				printedTr = true
-				if inRow {
-					table += "</tr>\n"
-				}
-				table += "<tr " + line[2:] + ">\n"
-				inRow = true
-				continue
+	wikimarkup := new(bytes.Buffer)
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".xlf", ".xliff":
+		if href := wikitext.Xliff12SkeletonHref(string(content)); href != "" {
+			skeleton, err := os.Open(filepath.Join(filepath.Dir(fileName), href))
+			if err != nil {
+				return err
 			}
+			defer skeleton.Close()
 
-			if strings.HasPrefix(line, "|") {
-				if !printedTr {
-					printedTr = true
-					inRow = true
-					table += "<tr>\n"
-				}
+			err = converter.FromXLIFF12(bytes.NewReader(content), skeleton, wikimarkup, importOptions)
+		} else {
+			err = converter.FromXLIFF(bytes.NewReader(content), wikimarkup, importOptions)
+		}
 
-				parts := strings.Split(line[1:], "|")
-				style := ""
-				body := ""
+	default:
+		err = converter.ToWiki(bytes.NewReader(content), wikimarkup, importOptions)
+	}
 
-				if len(parts) > 1 {
-					style = parts[0]
-					body = parts[1]
-				} else {
-					body = parts[0]
-				}
+	if err != nil {
+		return fmt.Errorf("%v: %w", fileName, err)
+	}
 
-				bodyParts := strings.Split(body, "!!")
+	if err := createOrReplaceFileWithBytes(fileName+".txt", wikimarkup.Bytes()); err != nil {
+		return err
+	}
 
-				for _, bodyPart := range bodyParts {
-					table += "<td " + style + ">" + bodyPart + "</td>\n"
-				}
+	fmt.Printf("Done\n")
 
-				continue
-			}
+	for _, category := range importOptions.Report.UnmappedCategories {
+		fmt.Printf("The category is not mapped: %v\n", category)
+	}
 
-			if strings.HasPrefix(line, "!") {
-				if !printedTr {
-					printedTr = true
-					inRow = true
-					table += "<tr>\n"
-				}
+	for _, link := range importOptions.Report.UnmappedLinks {
+		fmt.Printf("The link is not mapped: %v\n", link)
+	}
 
-				parts := strings.Split(line[1:], "|")
-				style := ""
-				body := ""
+	for _, escape := range importOptions.Report.EscapedMarkup {
+		fmt.Printf("Wiki markup was escaped: %v\n", escape)
+	}
 
-				if len(parts) > 1 {
-					style = parts[0]
-					body = parts[1]
-				} else {
-					body = parts[0]
-				}
+	return nil
+}
 
-				bodyParts := strings.Split(body, "!!")
+// fileImportOptions returns the options for importing a file. The files are
+// named after the page when they are exported, so the name is used for the
+// link back to the page if it was asked for. It is only used if the file does
+// not record the page.
+func fileImportOptions(fileName string) wikitext.Options {
+	importOptions := options
+	if *sourceFromFileName {
+		importOptions.SourceTitle = strings.Replace(
+			strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)), "_", " ", -1)
+	}
+	importOptions.Report = &wikitext.ImportReport{}
 
-				for _, bodyPart := range bodyParts {
-					table += "<th " + style + ">" + bodyPart + "</th>\n"
-				}
+	return importOptions
+}
 
-				continue
-			}
-		}
-		table += "</tr>\n"
+// exportTmx creates a translation memory from a page and the translated HTML
+// file. It is saved next to the HTML file.
+func exportTmx(pageURL, fileName, targetLanguage string) error {
+	page, err := readWikiPage(pageURL)
+	if err != nil {
+		return err
+	}
 
-		return table + "</table>"
-	})
+	translatedHtml, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer translatedHtml.Close()
 
-	// Random unbalanced left overs
-	wikimarkup = strings.Replace(wikimarkup, "'''", "<strong>", -1)
-	wikimarkup = strings.Replace(wikimarkup, "''", "<em>", -1)
+	tmx := new(bytes.Buffer)
+	err = converter.ToTMX(strings.NewReader(page.Wikitext), translatedHtml, tmx, targetLanguage, pageOptions(page))
+	if err != nil {
+		return fmt.Errorf("%v: %w", fileName, err)
+	}
 
-	return BalanceHtmlTags(wikimarkup)
-}
+	destinationPath := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".tmx"
+	if err := createOrReplaceFileWithString(destinationPath, tmx.String()); err != nil {
+		return err
+	}
 
-func downloadURL(url string) *bytes.Buffer {
-	response, err := http.Get(url)
-	check(err)
-	defer response.Body.Close()
+	fmt.Printf("The file has been created at: %v\n", destinationPath)
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(response.Body)
-	return buf
+	return nil
 }
 
-func createOrReplaceFileWithString(fileName, content string) {
-	fileHandle, err := os.Create(fileName)
-	check(err)
+// verifyPage checks that a page or wikitext file can be converted to HTML and
+// back with the options without any changes. An error is returned if it
+// cannot.
+func verifyPage(input string, options wikitext.Options) error {
+	page, err := readWikiPage(input)
+	if err != nil {
+		return err
+	}
 
-	writer := bufio.NewWriter(fileHandle)
-	defer fileHandle.Close()
+	differences, err := converter.Verify(strings.NewReader(page.Wikitext), options)
+	if err != nil {
+		return err
+	}
 
-	writer.WriteString(content)
-	writer.Flush()
-}
+	for _, difference := range differences {
+		fmt.Printf("%v\n\n", difference)
+	}
 
-func createOrReplaceFileWithBytes(fileName string, content []byte) {
-	fileHandle, err := os.Create(fileName)
-	check(err)
+	if len(differences) > 0 {
+		return fmt.Errorf("%d differences found, %w", len(differences), errLossy)
+	}
 
-	writer := bufio.NewWriter(fileHandle)
-	defer fileHandle.Close()
+	fmt.Printf("No differences found.\n")
 
-	writer.Write(content)
-	writer.Flush()
+	return nil
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Printf("Usage: %v <html file or wiki URL>\n\n", os.Args[0])
-		fmt.Printf("Examples:\n  %v https://en.wikipedia.org/wiki/Staffordshire_Bull_Terrier\n", os.Args[0])
-		fmt.Printf("  %v Staffordshire_Bull_Terrier.html\n\n", os.Args[0])
-		return
+func update() error {
+	fmt.Printf("The current version is v%v\n", wikitext.Version)
+	fmt.Printf("Finding the latest version... ")
+	latestReleaseJson, err := downloadURL(
+		"https://api.github.com/repos/elliotchance/wikitranslate/releases/latest")
+	if err != nil {
+		return err
 	}
 
-	input := os.Args[1]
-
-	if input == "update" {
-		fmt.Printf("The current version is v%v\n", Version)
-		fmt.Printf("Finding the latest version... ")
-		latestReleaseJson := downloadURL(
-			"https://api.github.com/repos/elliotchance/wikitranslate/releases/latest").String()
+	var latestRelease struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.Unmarshal(latestReleaseJson.Bytes(), &latestRelease); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(latestRelease.TagName, "v") {
+		return errors.New("could not find the latest version")
+	}
+	latestReleaseVersion := latestRelease.TagName[1:]
 
-		var latestRelease map[string]interface{}
-		json.Unmarshal([]byte(latestReleaseJson), &latestRelease)
-		latestReleaseVersion := latestRelease["tag_name"].(string)[1:]
+	fmt.Printf("v%v\n", latestReleaseVersion)
 
-		fmt.Printf("v%v\n", latestReleaseVersion)
+	if wikitext.Version == latestReleaseVersion {
+		fmt.Printf("You are running the latest version. No update required.\n\n")
+		return nil
+	}
 
-		if Version == latestReleaseVersion {
-			fmt.Printf("You are running the latest version. No update required.\n\n")
-			return
-		}
+	fmt.Printf("Downloading the latest version... ")
+	bin, err := downloadURL(fmt.Sprintf(
+		"https://github.com/elliotchance/wikitranslate/releases/download/v%v/wikitranslate-macosx", latestReleaseVersion))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Done (%.2f MB)\n", float64(bin.Len())/1048576.0)
 
-		fmt.Printf("Downloading the latest version... ")
-		bin := downloadURL(fmt.Sprintf(
-			"https://github.com/elliotchance/wikitranslate/releases/download/v%v/wikitranslate-macosx", latestReleaseVersion)).Bytes()
-		fmt.Printf("Done (%.2f MB)\n", float64(len(bin))/1048576.0)
+	fmt.Printf("Installing... ")
+	if err := createOrReplaceFileWithBytes(os.Args[0], bin.Bytes()); err != nil {
+		return err
+	}
+	fmt.Printf("Done\n\n")
 
-		fmt.Printf("Installing... ")
-		createOrReplaceFileWithBytes(os.Args[0], bin)
-		fmt.Printf("Done\n\n")
+	return nil
+}
 
-		return
+// run performs the command in the arguments.
+func run(args []string) error {
+	if err := loadOptions(); err != nil {
+		return err
 	}
 
-	if strings.HasPrefix(input, "http") {
-		fmt.Printf("Downloading page... ")
+	input := args[1]
 
-		tokens := strings.Split(input, "/")
-		title := strings.TrimSpace(tokens[len(tokens)-1])
+	switch {
+	case input == "update":
+		return update()
 
-		url := "https://en.wikipedia.org/w/index.php?title=" + title + "&action=edit"
-		content := downloadURL(url).String()
+	case input == "xliff" && len(args) > 2:
+		return exportPage(args[2], ".xlf", exportXliff)
 
-		re := regexp.MustCompile("(?s)<textarea.*?>(.*)</textarea>")
-		wikimarkup := html.UnescapeString(re.FindStringSubmatch(content)[1])
+	case input == "xliff12" && len(args) > 2:
+		return exportPageXliff12(args[2])
 
-		usr, err := user.Current()
-		if err != nil {
-			panic(err)
-		}
+	case input == "verify" && len(args) > 2:
+		return verifyPage(args[2], options)
 
-		destinationFolder := usr.HomeDir + "/Downloads"
-		destinationPath := destinationFolder + "/" + title + ".html"
+	case input == "tmx" && len(args) > 4:
+		return exportTmx(args[2], args[3], args[4])
 
-		fmt.Printf(" Done\nThe file has been created at: " + destinationPath + "\n")
+	case strings.HasPrefix(input, "http"):
+		return exportPage(input, ".html", exportHtml)
+	}
 
-		fileHandle, _ := os.Create(destinationPath)
-		writer := bufio.NewWriter(fileHandle)
-		defer fileHandle.Close()
+	return importFile(strings.TrimSpace(input))
+}
 
-		writer.WriteString(WikiToHtml(wikimarkup))
+// exitCode returns the exit code for an error returned by run.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errLossy):
+		return exitLossy
+
+	case errors.Is(err, wikitext.ErrCorruptPayload),
+		errors.Is(err, wikitext.ErrUnbalancedTemplate),
+		errors.Is(err, wikitext.ErrInvalidXliff),
+		errors.Is(err, wikitext.ErrInvalidTemplatePolicy),
+		errors.Is(err, wikitext.ErrInvalidTitleMap):
+		return exitInvalidInput
+
+	case errors.Is(err, wikitext.ErrFetchFailed):
+		return exitFetchFailed
+	}
 
-		writer.Flush()
-	} else {
-		fileName := strings.TrimSpace(input)
-		html, err := ioutil.ReadFile(fileName)
-		if err != nil {
-			panic(err)
-		}
+	return exitError
+}
 
-		fileHandle, _ := os.Create(fileName + ".txt")
-		writer := bufio.NewWriter(fileHandle)
-		defer fileHandle.Close()
+func usage() {
+	fmt.Printf("Usage: %v [options] <html file, xliff file or wiki URL>\n", os.Args[0])
+	fmt.Printf("       %v xliff <wiki URL>\n", os.Args[0])
+	fmt.Printf("       %v xliff12 <wiki URL>\n", os.Args[0])
+	fmt.Printf("       %v tmx <wiki URL> <translated html file> <target language>\n", os.Args[0])
+	fmt.Printf("       %v verify <wiki URL or wikitext file>\n\n", os.Args[0])
+	fmt.Printf("Examples:\n  %v https://en.wikipedia.org/wiki/Staffordshire_Bull_Terrier\n", os.Args[0])
+	fmt.Printf("  %v Staffordshire_Bull_Terrier.html\n", os.Args[0])
+	fmt.Printf("  %v xliff https://en.wikipedia.org/wiki/Staffordshire_Bull_Terrier\n", os.Args[0])
+	fmt.Printf("  %v Staffordshire_Bull_Terrier.xlf\n", os.Args[0])
+	fmt.Printf("  %v tmx https://en.wikipedia.org/wiki/Staffordshire_Bull_Terrier Staffordshire_Bull_Terrier.html es\n\n", os.Args[0])
+	fmt.Printf("Options:\n")
+	flag.PrintDefaults()
+	fmt.Printf("\n")
+}
 
-		writer.WriteString(HtmlToWiki(string(html)))
+func main() {
+	flag.Usage = usage
+	flag.Parse()
 
-		writer.Flush()
+	if flag.NArg() < 1 {
+		usage()
+		return
+	}
 
-		fmt.Printf("Done\n")
+	if err := run(append([]string{os.Args[0]}, flag.Args()...)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err))
 	}
 }
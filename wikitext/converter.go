@@ -0,0 +1,330 @@
+package wikitext
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Options changes how a Converter converts documents. The zero value converts
+// documents in the same way as WikiToHtml and HtmlToWiki.
+type Options struct {
+	// Templates decides which template arguments are shown to translators.
+	// All of them are shown if it is nil.
+	Templates *TemplatePolicy
+
+	// TranslateDisplayTitle shows the title in {{DISPLAYTITLE:...}} to
+	// translators. Otherwise it is hidden like the other magic words.
+	TranslateDisplayTitle bool
+
+	// ShowComments shows the text of comments to translators as a note that
+	// they can not change. Otherwise comments are hidden.
+	ShowComments bool
+
+	// OpaqueTags are the names of more extension tags (like <math>) whose
+	// content is never shown to translators. These are added to the built-in
+	// tags: math, chem, ce, syntaxhighlight, source, pre, score, timeline,
+	// templatestyles, graph and mapframe.
+	OpaqueTags []string
+
+	// Categories renames the categories when a translated document is
+	// converted back into wikitext. Categories are not changed if it is nil.
+	Categories *TitleMap
+
+	// Links changes the targets of internal links to the titles of the pages
+	// on the target wiki when a translated document is converted back into
+	// wikitext. Links are not changed if it is nil.
+	Links *TitleMap
+
+	// SourceLanguage (like "en") and SourceTitle are the wiki and the title
+	// of the page that is being translated. They are recorded in the exported
+	// document so that an interlanguage link back to the page can be added to
+	// the end of the translated document when it is imported. When importing,
+	// they are only used if the document does not record them (such as a
+	// document created by an older version). SourceLanguage is "en" if it is
+	// empty.
+	SourceLanguage string
+	SourceTitle    string
+
+	// Report, if it is not nil, is filled in when a translated document is
+	// converted back into wikitext.
+	Report *ImportReport
+}
+
+// ImportReport lists the things that need the attention of the translator
+// after a translated document has been converted back into wikitext.
+type ImportReport struct {
+	// UnmappedCategories are the categories that are not in
+	// Options.Categories. They have not been changed.
+	UnmappedCategories []string
+
+	// UnmappedLinks are the targets of internal links that are not in
+	// Options.Links. They have not been changed.
+	UnmappedLinks []string
+
+	// EscapedMarkup is the wiki markup that was typed by translators. It has
+	// been escaped so that it appears on the page as text.
+	EscapedMarkup []EscapedMarkup
+}
+
+// pageSource is the page that a document was exported from. Title is empty if
+// it is not known.
+type pageSource struct {
+	Language, Title string
+}
+
+// newPageSource returns the page recorded in a document. The language was
+// always "en" before it was recorded.
+func newPageSource(language, title string) pageSource {
+	if language == "" {
+		language = "en"
+	}
+
+	return pageSource{language, title}
+}
+
+// source returns the page in SourceLanguage and SourceTitle.
+func (o Options) source() pageSource {
+	return newPageSource(o.SourceLanguage, o.SourceTitle)
+}
+
+// opaqueTags returns the built-in opaque tags and the ones in OpaqueTags.
+func (o Options) opaqueTags() []string {
+	opaqueTags := append([]string{}, defaultOpaqueTags...)
+	for _, name := range o.OpaqueTags {
+		opaqueTags = append(opaqueTags, strings.ToLower(name))
+	}
+
+	return opaqueTags
+}
+
+// parseWiki parses wikitext and applies the options to the document tree.
+func (o Options) parseWiki(wikimarkup string) []Node {
+	nodes := parseWiki(wikimarkup, o.opaqueTags())
+	if o.Templates != nil {
+		hideTemplateArgs(nodes, o.Templates)
+	}
+	if o.TranslateDisplayTitle {
+		translateDisplayTitles(nodes, o.opaqueTags())
+	}
+	if o.ShowComments {
+		showComments(nodes)
+	}
+
+	return nodes
+}
+
+// localize prepares a translated document for the target wiki. A link back to
+// the source page is added if it has a title.
+func (o Options) localize(nodes []Node, source pageSource) []Node {
+	hasSourceLink := false
+
+	walkNodes(nodes, func(node Node) bool {
+		switch n := node.(type) {
+		case *Category:
+			if o.Categories == nil {
+				break
+			}
+
+			if target, ok := o.Categories.Lookup(n.Name); ok {
+				n.Name = target
+			} else if o.Report != nil && !contains(o.Report.UnmappedCategories, n.Name) {
+				o.Report.UnmappedCategories = append(o.Report.UnmappedCategories, n.Name)
+			}
+
+		case *Link:
+			if o.Links == nil || n.External {
+				break
+			}
+
+			// Only the page is mapped, the section (after #) stays the same.
+			parts := strings.SplitN(n.Target, "#", 2)
+			if parts[0] == "" || strings.HasPrefix(parts[0], ":") {
+				break
+			}
+
+			if target, ok := o.Links.Lookup(parts[0]); ok {
+				parts[0] = target
+				n.Target = strings.Join(parts, "#")
+			} else if o.Report != nil && !contains(o.Report.UnmappedLinks, parts[0]) {
+				o.Report.UnmappedLinks = append(o.Report.UnmappedLinks, parts[0])
+			}
+
+		case *LanguageLink:
+			if n.Language == source.Language {
+				hasSourceLink = true
+			}
+		}
+
+		return true
+	})
+
+	if source.Title != "" && !hasSourceLink {
+		nodes = append(nodes, &Text{"\n"}, &LanguageLink{source.Language, source.Title})
+	}
+
+	return nodes
+}
+
+// Converter converts between wikitext and the formats that are given to CAT
+// tools.
+type Converter struct {
+}
+
+// readWiki reads and parses all of the wikitext.
+func (c *Converter) readWiki(r io.Reader, options Options) ([]Node, error) {
+	wikimarkup, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return options.parseWiki(string(wikimarkup)), nil
+}
+
+// ToHTML reads wikitext and writes the pseudo-HTML.
+func (c *Converter) ToHTML(r io.Reader, w io.Writer, options Options) error {
+	nodes, err := c.readWiki(r, options)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, htmlPageSource(options.source())+BalanceHtmlTags(RenderHtml(nodes)))
+
+	return err
+}
+
+// ToXLIFF reads wikitext and writes an XLIFF 2.0 document.
+func (c *Converter) ToXLIFF(r io.Reader, w io.Writer, options Options) error {
+	nodes, err := c.readWiki(r, options)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, nodesToXliff(nodes, options.source()))
+
+	return err
+}
+
+// ToXLIFF12 reads wikitext and writes an XLIFF 1.2 document and its skeleton.
+// See WikiToXliff12.
+func (c *Converter) ToXLIFF12(r io.Reader, xliff, skeleton io.Writer, name string, options Options) error {
+	nodes, err := c.readWiki(r, options)
+	if err != nil {
+		return err
+	}
+
+	x, s := nodesToXliff12(nodes, name, options.source())
+	if _, err := io.WriteString(xliff, x); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(skeleton, s)
+
+	return err
+}
+
+// ToWiki reads the pseudo-HTML (after it has been translated) and writes the
+// wikitext.
+func (c *Converter) ToWiki(r io.Reader, w io.Writer, options Options) error {
+	html, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	nodes, source, err := parseHtmlDocument(string(html), options.opaqueTags())
+	if err != nil {
+		return err
+	}
+
+	return c.writeWiki(w, nodes, source, options)
+}
+
+// FromXLIFF reads an XLIFF 2.0 document (after it has been translated) and
+// writes the wikitext.
+func (c *Converter) FromXLIFF(r io.Reader, w io.Writer, options Options) error {
+	xliff, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	nodes, source, err := xliffToNodes(string(xliff), options.opaqueTags())
+	if err != nil {
+		return err
+	}
+
+	return c.writeWiki(w, nodes, source, options)
+}
+
+// FromXLIFF12 reads an XLIFF 1.2 document (after it has been translated) and
+// its skeleton and writes the wikitext.
+func (c *Converter) FromXLIFF12(r, skeleton io.Reader, w io.Writer, options Options) error {
+	xliff, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	skl, err := ioutil.ReadAll(skeleton)
+	if err != nil {
+		return err
+	}
+
+	nodes, source, err := xliff12ToNodes(string(xliff), string(skl), options.opaqueTags())
+	if err != nil {
+		return err
+	}
+
+	return c.writeWiki(w, nodes, source, options)
+}
+
+// ToTMX reads the original wikitext and the translated pseudo-HTML and writes a
+// TMX translation memory. See WikiToTmx.
+func (c *Converter) ToTMX(wiki, translatedHtml io.Reader, w io.Writer, targetLanguage string, options Options) error {
+	wikimarkup, err := ioutil.ReadAll(wiki)
+	if err != nil {
+		return err
+	}
+
+	html, err := ioutil.ReadAll(translatedHtml)
+	if err != nil {
+		return err
+	}
+
+	tmx, err := wikiToTmx(string(wikimarkup), string(html), targetLanguage, options)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, tmx)
+
+	return err
+}
+
+// Verify reads wikitext and converts it to the pseudo-HTML and back again with
+// the options. See VerifyRoundTrip. The categories and links are not mapped
+// because that is meant to change the wikitext.
+func (c *Converter) Verify(r io.Reader, options Options) ([]RoundTripDifference, error) {
+	wikimarkup, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyRoundTrip(string(wikimarkup), options)
+}
+
+// writeWiki applies the options to a translated document and writes it as
+// wikitext. source is the page recorded in the document, if there is one.
+func (c *Converter) writeWiki(w io.Writer, nodes []Node, source pageSource, options Options) error {
+	if source.Title == "" {
+		source = options.source()
+	}
+	nodes = options.localize(nodes, source)
+
+	escapes := escapeMarkup(nodes, options.opaqueTags())
+	if options.Report != nil {
+		options.Report.EscapedMarkup = append(options.Report.EscapedMarkup, escapes...)
+	}
+
+	_, err := io.WriteString(w, RenderWiki(nodes))
+
+	return err
+}
@@ -0,0 +1,334 @@
+package wikitext
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConverter(t *testing.T) {
+	converter := &Converter{}
+
+	for _, test := range examples {
+		html := new(bytes.Buffer)
+		if err := converter.ToHTML(strings.NewReader(test.wiki), html, Options{}); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if html.String() != test.html {
+			t.Errorf("%v: expected HTML '%v', got '%v'", test.name, test.html, html)
+		}
+
+		expected := test.newWiki
+		if expected == "" {
+			expected = test.wiki
+		}
+
+		wiki := new(bytes.Buffer)
+		if err := converter.ToWiki(strings.NewReader(test.html), wiki, Options{}); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if wiki.String() != expected {
+			t.Errorf("%v: expected wiki '%v', got '%v'", test.name, expected, wiki)
+		}
+	}
+}
+
+func TestConverterToWikiCorruptPayload(t *testing.T) {
+	wiki := new(bytes.Buffer)
+	err := (&Converter{}).ToWiki(strings.NewReader(`foo <ref data="%%%"></ref>`), wiki, Options{})
+
+	if !errors.Is(err, ErrCorruptPayload) {
+		t.Errorf("Expected a corrupt data error, got %v", err)
+	}
+	if wiki.Len() != 0 {
+		t.Errorf("Expected nothing to be written, got '%v'", wiki)
+	}
+}
+
+func TestConverterTranslateDisplayTitle(t *testing.T) {
+	converter := &Converter{}
+	options := Options{TranslateDisplayTitle: true}
+	wiki := "{{DISPLAYTITLE:''Foo'' bar}}\n{{DISPLAYTITLE:Baz|noreplace}}\n{{DEFAULTSORT:Qux}}"
+
+	html := new(bytes.Buffer)
+	if err := converter.ToHTML(strings.NewReader(wiki), html, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<magic name="DISPLAYTITLE" data="e3tESVNQTEFZVElUTEU6"><em>Foo</em> bar</magic>` + "\n" +
+		`<magic data="e3tESVNQTEFZVElUTEU6QmF6fG5vcmVwbGFjZX19"></magic>` + "\n" +
+		`<magic data="e3tERUZBVUxUU09SVDpRdXh9fQ=="></magic>`
+	if html.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, html)
+	}
+
+	result := new(bytes.Buffer)
+	translated := strings.Replace(html.String(), "</em> bar", "</em> barre", 1)
+	if err := converter.ToWiki(strings.NewReader(translated), result, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedWiki := strings.Replace(wiki, "'' bar", "'' barre", 1)
+	if result.String() != expectedWiki {
+		t.Errorf("Expected '%v', got '%v'", expectedWiki, result)
+	}
+
+	xliff := new(bytes.Buffer)
+	if err := converter.ToXLIFF(strings.NewReader(wiki), xliff, options); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xliff.String(), "</pc> bar</pc>") {
+		t.Errorf("Expected the title to be translatable in %v", xliff)
+	}
+	if result, err := XliffToWiki(xliff.String()); err != nil || result != wiki {
+		t.Errorf("Expected '%v' from XLIFF, got '%v' (%v)", wiki, result, err)
+	}
+}
+
+func TestConverterShowComments(t *testing.T) {
+	converter := &Converter{}
+	options := Options{ShowComments: true}
+	wiki := `Foo<!-- Do not use "bar" here --> baz`
+
+	html := new(bytes.Buffer)
+	if err := converter.ToHTML(strings.NewReader(wiki), html, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Foo<comment data="IERvIG5vdCB1c2UgImJhciIgaGVyZSA=" note=" Do not use &quot;bar&quot; here "></comment> baz`
+	if html.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, html)
+	}
+
+	result := new(bytes.Buffer)
+	if err := converter.ToWiki(html, result, options); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != wiki {
+		t.Errorf("Expected '%v', got '%v'", wiki, result)
+	}
+
+	xliff := new(bytes.Buffer)
+	if err := converter.ToXLIFF(strings.NewReader(wiki), xliff, options); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xliff.String(), `disp=" Do not use &quot;bar&quot; here "`) {
+		t.Errorf("Expected the comment to be displayed in %v", xliff)
+	}
+	if result, err := XliffToWiki(xliff.String()); err != nil || result != wiki {
+		t.Errorf("Expected '%v' from XLIFF, got '%v' (%v)", wiki, result, err)
+	}
+
+	xliff.Reset()
+	skeleton := new(bytes.Buffer)
+	if err := converter.ToXLIFF12(strings.NewReader(wiki), xliff, skeleton, "Foo", options); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xliff.String(), `ctype="x-wt-comment"> Do not use "bar" here </ph>`) {
+		t.Errorf("Expected the comment to be displayed in %v", xliff)
+	}
+	if result, err := Xliff12ToWiki(xliff.String(), skeleton.String()); err != nil || result != wiki {
+		t.Errorf("Expected '%v' from XLIFF 1.2, got '%v' (%v)", wiki, result, err)
+	}
+}
+
+func TestConverterOpaqueTags(t *testing.T) {
+	converter := &Converter{}
+	options := Options{OpaqueTags: []string{"Hiero"}}
+	wiki := "Foo <hiero>A1|B2\n''C3''</hiero> <math>x</math>"
+
+	html := new(bytes.Buffer)
+	if err := converter.ToHTML(strings.NewReader(wiki), html, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Foo <tag name="hiero" data="QTF8QjIKJydDMycn"></tag> <tag name="math" data="eA=="></tag>`
+	if html.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, html)
+	}
+
+	result := new(bytes.Buffer)
+	if err := converter.ToWiki(html, result, options); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != wiki {
+		t.Errorf("Expected '%v', got '%v'", wiki, result)
+	}
+
+	// Without the option the content of <hiero> is translatable.
+	html.Reset()
+	converter.ToHTML(strings.NewReader(wiki), html, Options{})
+	if strings.Contains(html.String(), `name="hiero"`) {
+		t.Errorf("Expected <hiero> to be translatable, got %v", html)
+	}
+}
+
+func TestConverterTranslateDisplayTitleOpaqueTags(t *testing.T) {
+	options := Options{TranslateDisplayTitle: true, OpaqueTags: []string{"hiero"}}
+	wiki := "{{DISPLAYTITLE:''Foo'' <hiero>A1|B2</hiero>}}"
+
+	html := new(bytes.Buffer)
+	if err := (&Converter{}).ToHTML(strings.NewReader(wiki), html, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<magic name="DISPLAYTITLE" data="e3tESVNQTEFZVElUTEU6"><em>Foo</em> <tag name="hiero" data="QTF8QjI="></tag></magic>`
+	if html.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, html)
+	}
+}
+
+func TestConverterOpaqueTagsImport(t *testing.T) {
+	converter := &Converter{}
+	options := Options{OpaqueTags: []string{"Hiero"}}
+	wiki := "Foo <hiero>A1|[[B2</hiero> {{x|<hiero>a=b</hiero>}}"
+
+	xliff := new(bytes.Buffer)
+	if err := converter.ToXLIFF(strings.NewReader(wiki), xliff, options); err != nil {
+		t.Fatal(err)
+	}
+
+	result := new(bytes.Buffer)
+	if err := converter.FromXLIFF(xliff, result, options); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != wiki {
+		t.Errorf("Expected '%v', got '%v'", wiki, result)
+	}
+
+	xliff.Reset()
+	skeleton := new(bytes.Buffer)
+	if err := converter.ToXLIFF12(strings.NewReader(wiki), xliff, skeleton, "Foo", options); err != nil {
+		t.Fatal(err)
+	}
+
+	result.Reset()
+	if err := converter.FromXLIFF12(xliff, skeleton, result, options); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != wiki {
+		t.Errorf("Expected '%v', got '%v'", wiki, result)
+	}
+}
+
+func TestConverterEscapedMarkup(t *testing.T) {
+	report := &ImportReport{}
+	options := Options{Report: report}
+	html := "Signed ~~~~\n<template name=\"foo\"><arg name=\"\">a|b</arg></template> [[Bar]]"
+
+	wiki := new(bytes.Buffer)
+	if err := (&Converter{}).ToWiki(strings.NewReader(html), wiki, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Signed <nowiki>~~~~</nowiki>\n{{foo|a<nowiki>|</nowiki>b}} <nowiki>[[</nowiki>Bar]]"
+	if wiki.String() != expected {
+		t.Errorf("Expected '%v', got '%v'", expected, wiki)
+	}
+
+	escapes := []EscapedMarkup{{"~~~~", "Signed ~~~~"}, {"|", "a|b"}, {"[[", "[[Bar]]"}}
+	if !reflect.DeepEqual(report.EscapedMarkup, escapes) {
+		t.Errorf("Expected %v, got %v", escapes, report.EscapedMarkup)
+	}
+}
+
+func TestConverterVerify(t *testing.T) {
+	wiki := "<foo>\n{|\n|-\n  | x\n|}\n</foo>"
+
+	differences, err := (&Converter{}).Verify(strings.NewReader(wiki), Options{})
+	if err != nil || len(differences) != 1 {
+		t.Errorf("Expected a difference in the table, got %v (%v)", differences, err)
+	}
+
+	differences, err = (&Converter{}).Verify(strings.NewReader(wiki), Options{OpaqueTags: []string{"foo"}})
+	if err != nil || len(differences) != 0 {
+		t.Errorf("Expected no differences in an opaque tag, got %v (%v)", differences, err)
+	}
+}
+
+func TestConverterSourcePage(t *testing.T) {
+	converter := &Converter{}
+	exportOptions := Options{SourceLanguage: "fr", SourceTitle: "Chien"}
+	expected := "Foo\n[[fr:Chien]]"
+
+	html := new(bytes.Buffer)
+	if err := converter.ToHTML(strings.NewReader("Foo"), html, exportOptions); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(html.String(), `<page language="fr" data="Q2hpZW4="></page>`) {
+		t.Errorf("Expected the page to be recorded, got '%v'", html)
+	}
+
+	// The recorded page is used instead of the options.
+	result := new(bytes.Buffer)
+	if err := converter.ToWiki(html, result, Options{SourceTitle: "Dog"}); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != expected {
+		t.Errorf("Expected '%v', got '%v'", expected, result)
+	}
+
+	xliff := new(bytes.Buffer)
+	if err := converter.ToXLIFF(strings.NewReader("Foo"), xliff, exportOptions); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xliff.String(), `srcLang="fr"`) {
+		t.Errorf("Expected the source language to be fr, got '%v'", xliff)
+	}
+
+	result.Reset()
+	if err := converter.FromXLIFF(xliff, result, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != expected {
+		t.Errorf("Expected '%v', got '%v'", expected, result)
+	}
+
+	xliff.Reset()
+	skeleton := new(bytes.Buffer)
+	if err := converter.ToXLIFF12(strings.NewReader("Foo"), xliff, skeleton, "Chien", exportOptions); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xliff.String(), `source-language="fr"`) {
+		t.Errorf("Expected the source language to be fr, got '%v'", xliff)
+	}
+
+	result.Reset()
+	if err := converter.FromXLIFF12(xliff, skeleton, result, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != expected {
+		t.Errorf("Expected '%v', got '%v'", expected, result)
+	}
+
+	tmx := new(bytes.Buffer)
+	if err := converter.ToTMX(strings.NewReader("Foo"), strings.NewReader("Le foo"), tmx, "de", exportOptions); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(tmx.String(), `srclang="fr"`) {
+		t.Errorf("Expected the source language to be fr, got '%v'", tmx)
+	}
+}
+
+func TestConverterSourcePageNotRecorded(t *testing.T) {
+	converter := &Converter{}
+
+	for _, test := range []struct {
+		options  Options
+		expected string
+	}{
+		{Options{}, "Foo"},
+		{Options{SourceTitle: "Dog"}, "Foo\n[[en:Dog]]"},
+		{Options{SourceLanguage: "de", SourceTitle: "Hund"}, "Foo\n[[de:Hund]]"},
+	} {
+		result := new(bytes.Buffer)
+		if err := converter.ToWiki(strings.NewReader("Foo"), result, test.options); err != nil {
+			t.Fatal(err)
+		}
+		if result.String() != test.expected {
+			t.Errorf("Expected '%v', got '%v'", test.expected, result)
+		}
+	}
+}
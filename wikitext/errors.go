@@ -0,0 +1,87 @@
+package wikitext
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The kinds of errors that can happen. They will always be wrapped in an
+// *Error so use errors.Is, or compare with the Err of the *Error.
+var (
+	// ErrCorruptPayload means the hidden content of a <ref> or <nowiki> could
+	// not be decoded. This usually happens when the data attribute has been
+	// changed or removed.
+	ErrCorruptPayload = errors.New("corrupt payload")
+
+	// ErrUnbalancedTemplate means that a <template> or <arg> was not closed.
+	ErrUnbalancedTemplate = errors.New("unbalanced template")
+
+	// ErrInvalidXliff means that an XLIFF file (or its skeleton) is not valid
+	// XML.
+	ErrInvalidXliff = errors.New("invalid XLIFF")
+
+	// ErrInvalidTemplatePolicy means that a line of a template rules file
+	// could not be understood.
+	ErrInvalidTemplatePolicy = errors.New("invalid template rule")
+
+	// ErrInvalidTitleMap means that a line of a file of titles (such as the
+	// category mapping) could not be understood.
+	ErrInvalidTitleMap = errors.New("invalid title mapping")
+
+	// ErrFetchFailed means that a page could not be downloaded.
+	ErrFetchFailed = errors.New("fetch failed")
+)
+
+// Error describes a problem with a document and where it was found.
+type Error struct {
+	// Err is one of the Err variables above.
+	Err error
+
+	// Offset is the number of bytes from the start of the document. Line
+	// starts at 1. Line will be 0 if the error is not for a position in a
+	// document.
+	Offset int
+	Line   int
+
+	// Element is the element, tag or URL involved.
+	Element string
+
+	// Cause is the underlying error, if there is one.
+	Cause error
+}
+
+// newError creates an error at an offset of the input.
+func newError(err error, input string, offset int, element string, cause error) *Error {
+	if offset > len(input) {
+		offset = len(input)
+	}
+
+	return &Error{
+		Err:     err,
+		Offset:  offset,
+		Line:    strings.Count(input[:offset], "\n") + 1,
+		Element: element,
+		Cause:   cause,
+	}
+}
+
+func (e *Error) Error() string {
+	message := e.Err.Error()
+	if e.Element != "" {
+		message += " in " + e.Element
+	}
+	if e.Line > 0 {
+		message = fmt.Sprintf("line %d: %v", e.Line, message)
+	}
+	if e.Cause != nil {
+		message += ": " + e.Cause.Error()
+	}
+
+	return message
+}
+
+// Unwrap allows errors.Is to be used with the Err variables.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,146 @@
+package wikitext
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHtmlToWikiErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		html    string
+		err     error
+		offset  int
+		line    int
+		element string
+		message string
+	}{
+		{"e101", "foo\nbar <ref data=\"%%%\"></ref>", ErrCorruptPayload, 8, 2, "<ref>",
+			"line 2: corrupt payload in <ref>: illegal base64 data at input byte 0"},
+		{"e102", "<nowiki data=\"Zm9v\"></nowiki>\n\n<nowiki data=\"Zm9\"></nowiki>", ErrCorruptPayload, 31, 3, "<nowiki>",
+			"line 3: corrupt payload in <nowiki>: illegal base64 data at input byte 0"},
+		{"e103", "<ref data=\"\" attributes=\"%%%\"></ref>", ErrCorruptPayload, 0, 1, "<ref>",
+			"line 1: corrupt payload in <ref>: illegal base64 data at input byte 0"},
+		{"e104", "foo <ref name=\"a\">[[Bar]]</ref>", ErrCorruptPayload, 4, 1, "<ref>",
+			"line 1: corrupt payload in <ref>: the content is not in the data attribute"},
+		{"e105", "<nowiki>\n  <em>foo</em>\n</nowiki>", ErrCorruptPayload, 0, 1, "<nowiki>",
+			"line 1: corrupt payload in <nowiki>: the content is not in the data attribute"},
+		{"e201", "foo <template name=\"bar\">baz", ErrUnbalancedTemplate, 4, 1, "<template>",
+			"line 1: unbalanced template in <template>"},
+		{"e202", "<em>\n<template name=\"bar\"><arg name=\"\">baz</template>\n</em>", ErrUnbalancedTemplate, 26, 2, "<arg>",
+			"line 2: unbalanced template in <arg>"},
+	}
+
+	for _, test := range tests {
+		_, err := HtmlToWiki(test.html)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.err, err)
+			continue
+		}
+
+		e := err.(*Error)
+		if e.Offset != test.offset || e.Line != test.line || e.Element != test.element {
+			t.Errorf("%v: expected offset %d, line %d and %v, got %d, %d and %v",
+				test.name, test.offset, test.line, test.element, e.Offset, e.Line, e.Element)
+		}
+		if e.Error() != test.message {
+			t.Errorf("%v: expected '%v', got '%v'", test.name, test.message, e.Error())
+		}
+	}
+}
+
+func TestXliffToWikiErrors(t *testing.T) {
+	xliff := WikiToXliff("foo '''bar'''")
+	_, err := XliffToWiki(xliff[:len(xliff)-20])
+	if !errors.Is(err, ErrInvalidXliff) || err.(*Error).Line != 12 {
+		t.Errorf("Expected an invalid XLIFF error on line 12, got %v", err)
+	}
+
+	xliff, skeleton := WikiToXliff12("foo '''bar'''", "Foo")
+	_, err = Xliff12ToWiki(xliff, skeleton[:len(skeleton)-20])
+	if !errors.Is(err, ErrInvalidXliff) {
+		t.Errorf("Expected an invalid XLIFF error, got %v", err)
+	}
+}
+
+func TestXliffToWikiInvalidCodes(t *testing.T) {
+	wiki := "a [[dog|b]] [http://x c] [[File:a.png|thumb|cap]] {{#if:x|y}}"
+
+	for _, test := range []struct {
+		name     string
+		old, new string
+	}{
+		{"x101", `<data id="d1">[[dog|</data>`, `<data id="d1"></data>`},
+		{"x102", `<data id="d1">[[dog|</data>`, `<data id="d1">[[</data>`},
+		{"x103", `<data id="d3">[http://x </data>`, `<data id="d3">x</data>`},
+		{"x104", `<data id="d5">[[File:a.png</data>`, `<data id="d5">[[a.png</data>`},
+		{"x105", `<data id="d5">[[File:a.png</data>`, `<data id="d5"></data>`},
+		{"x106", `<data id="d9">{{#if:x</data>`, `<data id="d9">{{#if</data>`},
+		{"x107", `<data id="d9">{{#if:x</data>`, `<data id="d9"></data>`},
+	} {
+		xliff := WikiToXliff(wiki)
+		if !strings.Contains(xliff, test.old) {
+			t.Fatalf("%v: %v is not in %v", test.name, test.old, xliff)
+		}
+
+		_, err := XliffToWiki(strings.Replace(xliff, test.old, test.new, 1))
+		if !errors.Is(err, ErrInvalidXliff) {
+			t.Errorf("%v: expected an invalid XLIFF error, got %v", test.name, err)
+		}
+	}
+}
+
+func TestXliffToWikiMissingCodes(t *testing.T) {
+	wiki := "foo '''bar''' {{baz|a=qux}}<ref>x</ref>"
+
+	for _, test := range []struct {
+		name     string
+		old, new string
+	}{
+		{"x201", ` dataRef="d6"`, ``},
+		{"x202", `dataRef="d6"`, `dataRef="d60"`},
+		{"x203", `dataRefStart="d3"`, `dataRefStart="d30"`},
+		{"x204", `dataRefStart="d3"`, ``},
+		{"x205", `subType="wt:ref"`, `subType="wt:foo"`},
+		{"x206", `subType="wt:template"`, `subType="wt:foo"`},
+		{"x207", `subType="wt:template"`, ``},
+	} {
+		xliff := WikiToXliff(wiki)
+		if !strings.Contains(xliff, test.old) {
+			t.Fatalf("%v: %v is not in %v", test.name, test.old, xliff)
+		}
+
+		_, err := XliffToWiki(strings.Replace(xliff, test.old, test.new, 1))
+		if !errors.Is(err, ErrInvalidXliff) {
+			t.Errorf("%v: expected an invalid XLIFF error, got %v", test.name, err)
+		}
+	}
+
+	for _, test := range []struct {
+		name                 string
+		old, new             string
+		replaceInTheSkeleton bool
+	}{
+		{"x301", `<code id="3" kind="arg">|a=</code>`, ``, true},
+		{"x302", `kind="template"`, `kind="foo"`, true},
+		{"x303", `kind="ref"`, ``, true},
+		{"x304", `<ph id="4" ctype="x-wt-ref"></ph>`, `<ph id="40" ctype="x-wt-ref"></ph>`, false},
+		{"x305", `<bpt id="2" ctype="x-wt-template"></bpt>`, `<bpt id="20" ctype="x-wt-template"></bpt>`, false},
+	} {
+		xliff, skeleton := WikiToXliff12(wiki, "Foo")
+		document := &xliff
+		if test.replaceInTheSkeleton {
+			document = &skeleton
+		}
+		if !strings.Contains(*document, test.old) {
+			t.Fatalf("%v: %v is not in %v", test.name, test.old, *document)
+		}
+		*document = strings.Replace(*document, test.old, test.new, 1)
+
+		_, err := Xliff12ToWiki(xliff, skeleton)
+		if !errors.Is(err, ErrInvalidXliff) {
+			t.Errorf("%v: expected an invalid XLIFF error, got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package wikitext
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// EscapedMarkup is wiki markup that was typed by a translator, such as "[[" or
+// a signature. It has been escaped so that it appears on the page as it was
+// typed.
+type EscapedMarkup struct {
+	// Markup is what was escaped.
+	Markup string
+
+	// Text is the line of translated text that it was found in.
+	Text string
+}
+
+func (e EscapedMarkup) String() string {
+	return fmt.Sprintf("%q in %q", e.Markup, e.Text)
+}
+
+// textContext describes the construct that some text is inside of. It decides
+// which characters have a meaning in wikitext.
+type textContext struct {
+	// closing is the end of the construct, like "}}" or "]]".
+	closing string
+
+	// pipe is true if a "|" starts the next part of the construct.
+	pipe bool
+
+	// equals is true if an "=" would turn a positional argument into a named
+	// argument.
+	equals bool
+
+	// cell is true for the content of a table cell or caption. header is
+	// true if the cell is a header cell.
+	cell, header bool
+
+	// term is true for the term of a definition list (;) where a ":" starts
+	// the definition.
+	term bool
+}
+
+// markupEscaper escapes the wiki markup in the translated text of a document.
+// Only the markup that would be parsed again is escaped. The text that was
+// in the original wikitext is never changed because it was not parsed as
+// markup the first time. Markup at the start of a line is checked together
+// with the rest of the line (following) because a heading or a table depends
+// on what comes after the text.
+type markupEscaper struct {
+	opaqueTags []string
+	escapes    []EscapedMarkup
+	following  []Node
+
+	// lineStart is true when the next character is at the start of a line.
+	// itemStart is true when it is straight after the prefix of a list item.
+	lineStart, itemStart bool
+
+	// firstLine is true on the first line of a table cell, where "||"
+	// starts another cell. attributes is true if a "|" would make the text
+	// before it the attributes of the cell.
+	firstLine, attributes bool
+}
+
+// escapeMarkup escapes the wiki markup in all of the Text nodes and returns
+// what was escaped.
+func escapeMarkup(nodes []Node, opaqueTags []string) []EscapedMarkup {
+	e := &markupEscaper{opaqueTags: opaqueTags, lineStart: true}
+	e.escapeNodes(nodes, textContext{})
+
+	return e.escapes
+}
+
+func (e *markupEscaper) escapeNodes(nodes []Node, context textContext) {
+	for i, node := range nodes {
+		// Only text can continue the prefix of a list item.
+		if _, ok := node.(*Text); !ok {
+			e.itemStart = false
+		}
+
+		switch n := node.(type) {
+		case *Text:
+			e.following = nodes[i+1:]
+			n.Value = e.escapeText(n.Value, context)
+			e.following = nil
+			continue
+
+		case *Bold:
+			e.lineStart = false
+			e.escapeNodes(n.Children, context)
+
+		case *Italic:
+			e.lineStart = false
+			e.escapeNodes(n.Children, context)
+
+		case *Link:
+			e.lineStart, e.attributes = false, false
+			if n.External {
+				e.escapeNodes(n.Children, textContext{closing: "]"})
+			} else {
+				e.escapeNodes(n.Children, textContext{closing: "]]"})
+			}
+
+		case *Image:
+			e.lineStart, e.attributes = false, false
+			for _, option := range n.Options {
+				if !option.Hidden {
+					e.escapeNodes(option.Children, textContext{closing: "]]", pipe: true})
+				}
+			}
+
+		case *Template:
+			e.escapeArgs(n.Args, true)
+
+		case *ParserFunction:
+			// Only the cases of a #switch have names.
+			e.escapeArgs(n.Args, strings.ToLower(strings.TrimSpace(n.Name)) == "#switch")
+
+		case *MagicWord:
+			e.lineStart = false
+			e.escapeNodes(n.Children, textContext{closing: "}}", pipe: true})
+
+		case *Heading:
+			e.lineStart = false
+			e.escapeNodes(n.Children, textContext{})
+
+		case *List:
+			for _, item := range n.Items {
+				e.lineStart, e.itemStart = false, !item.Inline
+				e.escapeNodes(item.Children, textContext{term: strings.HasSuffix(item.Prefix, ";")})
+				e.itemStart = false
+			}
+
+		case *Table:
+			if n.Caption != nil {
+				e.escapeCell(n.Caption.Children, n.Caption.Attributes, false)
+			}
+			for _, row := range n.Rows {
+				for _, cell := range row.Cells {
+					e.escapeCell(cell.Children, cell.Attributes, cell.Header)
+				}
+			}
+		}
+
+		e.lineStart = false
+	}
+
+	for i, node := range nodes {
+		switch node.(type) {
+		case *Bold, *Italic:
+			e.escapeApostrophes(nodes, i)
+		}
+	}
+}
+
+// escapeApostrophes escapes the apostrophes in the text on either side of the
+// delimiters of a Bold or Italic (nodes[i]) if they would be read as part of
+// the delimiter. For example, an apostrophe at the start of italic text would
+// turn it into bold. Apostrophes that are read the same way (like an
+// apostrophe before bold text that was already in the wikitext) are left
+// alone.
+func (e *markupEscaper) escapeApostrophes(nodes []Node, i int) {
+	var children []Node
+	switch n := nodes[i].(type) {
+	case *Bold:
+		children = n.Children
+	case *Italic:
+		children = n.Children
+	}
+
+	var before, after, first, last *Text
+	if i > 0 {
+		before, _ = nodes[i-1].(*Text)
+	}
+	if i+1 < len(nodes) {
+		after, _ = nodes[i+1].(*Text)
+	}
+	if len(children) > 0 {
+		first, _ = children[0].(*Text)
+		last, _ = children[len(children)-1].(*Text)
+	}
+
+	prefix, suffix := "", ""
+	if before != nil {
+		prefix = before.Value[len(strings.TrimRight(before.Value, "'")):]
+	}
+	if after != nil {
+		suffix = after.Value[:len(after.Value)-len(strings.TrimLeft(after.Value, "'"))]
+	}
+
+	if prefix == "" && suffix == "" &&
+		(first == nil || !strings.HasPrefix(first.Value, "'")) &&
+		(last == nil || !strings.HasSuffix(last.Value, "'")) {
+		return
+	}
+
+	expected := []Node{nodes[i]}
+	if prefix != "" {
+		expected = append([]Node{&Text{prefix}}, expected...)
+	}
+	if suffix != "" {
+		expected = append(expected, &Text{suffix})
+	}
+
+	p := &wikiParser{input: RenderWiki(expected), opaqueTags: e.opaqueTags}
+	parsed := p.parseNodes(nil)
+
+	same := len(parsed) == len(expected)
+	for j := 0; same && j < len(parsed); j++ {
+		same = reflect.TypeOf(parsed[j]) == reflect.TypeOf(expected[j]) &&
+			RenderWiki(parsed[j:j+1]) == RenderWiki(expected[j:j+1])
+	}
+	if same {
+		return
+	}
+
+	for _, text := range []*Text{before, last} {
+		if text != nil {
+			text.Value = e.escapeApostrophesAt(text.Value, false)
+		}
+	}
+	for _, text := range []*Text{first, after} {
+		if text != nil {
+			text.Value = e.escapeApostrophesAt(text.Value, true)
+		}
+	}
+}
+
+// escapeApostrophesAt escapes the apostrophes at the start (or the end) of s.
+func (e *markupEscaper) escapeApostrophesAt(s string, start bool) string {
+	if start {
+		rest := strings.TrimLeft(s, "'")
+		if rest == s {
+			return s
+		}
+		e.escapes = append(e.escapes, EscapedMarkup{s[:len(s)-len(rest)], lineAt(s, 0)})
+		return "<nowiki>" + s[:len(s)-len(rest)] + "</nowiki>" + rest
+	}
+
+	rest := strings.TrimRight(s, "'")
+	if rest == s {
+		return s
+	}
+	e.escapes = append(e.escapes, EscapedMarkup{s[len(rest):], lineAt(s, len(s)-1)})
+	return rest + "<nowiki>" + s[len(rest):] + "</nowiki>"
+}
+
+func (e *markupEscaper) escapeArgs(args []*Arg, named bool) {
+	for _, arg := range args {
+		if !arg.Hidden {
+			e.lineStart = false
+			e.escapeNodes(arg.Children, textContext{closing: "}}", pipe: true, equals: named && arg.Name == ""})
+		}
+	}
+}
+
+func (e *markupEscaper) escapeCell(children []Node, attributes string, header bool) {
+	firstLine, cellAttributes := e.firstLine, e.attributes
+	e.lineStart, e.firstLine, e.attributes = false, true, attributes == ""
+
+	e.escapeNodes(children, textContext{cell: true, header: header})
+
+	e.firstLine, e.attributes = firstLine, cellAttributes
+}
+
+func (e *markupEscaper) escapeText(s string, context textContext) string {
+	buf := new(bytes.Buffer)
+
+	for i := 0; i < len(s); {
+		if n := e.markupAt(s, i, context); n > 0 {
+			markup := s[i : i+n]
+			e.escapes = append(e.escapes, EscapedMarkup{markup, lineAt(s, i)})
+			if markup == "<" {
+				buf.WriteString("&lt;")
+			} else {
+				buf.WriteString("<nowiki>" + markup + "</nowiki>")
+			}
+			e.lineStart, e.itemStart = false, false
+			i += n
+			continue
+		}
+
+		if s[i] == '\n' {
+			e.lineStart, e.firstLine = true, false
+		} else {
+			e.lineStart = false
+		}
+		e.itemStart = false
+		buf.WriteByte(s[i])
+		i++
+	}
+
+	return buf.String()
+}
+
+// markupAt returns the length of the markup that starts at s[i], or 0 if there
+// is none.
+func (e *markupEscaper) markupAt(s string, i int, context textContext) int {
+	rest := s[i:]
+
+	if e.lineStart {
+		if context.cell && (rest[0] == '|' || rest[0] == '!') {
+			return 1
+		}
+
+		// Headings, lists and tables. The tree is only different if the
+		// construct starts in the text rather than in the nodes after it
+		// (like the indent before a heading).
+		p := &wikiParser{input: rest + renderLine(e.following), lineStart: true, opaqueTags: e.opaqueTags}
+		start := 0
+		for _, node := range p.parseLine() {
+			text, ok := node.(*Text)
+			if !ok {
+				if start < len(rest) {
+					return 1
+				}
+				break
+			}
+			start += len(text.Value)
+		}
+	}
+
+	// More of the prefix of a list item.
+	if e.itemStart && strings.IndexByte("#*:;", rest[0]) >= 0 {
+		return 1
+	}
+
+	if context.term && rest[0] == ':' {
+		return 1
+	}
+
+	// A signature (~~~~) is replaced when the page is saved.
+	if tildes := countPrefix(rest, '~'); tildes >= 3 {
+		return tildes
+	}
+
+	if context.closing != "" && strings.HasPrefix(rest, context.closing) {
+		return len(context.closing)
+	}
+
+	if context.pipe && rest[0] == '|' {
+		return 1
+	}
+
+	if context.cell && e.firstLine && (strings.HasPrefix(rest, "||") ||
+		(context.header && strings.HasPrefix(rest, "!!"))) {
+		return 2
+	}
+
+	if context.cell && e.attributes && e.firstLine && rest[0] == '|' {
+		e.attributes = false
+		return 1
+	}
+
+	// Every "=" has to be escaped because the next one would be used instead.
+	if context.equals && rest[0] == '=' {
+		return 1
+	}
+
+	switch rest[0] {
+	case '[', '{', '<', '_', '\'':
+		if strings.HasPrefix(rest, "[[") {
+			e.attributes = false
+		}
+
+		p := &wikiParser{input: rest, opaqueTags: e.opaqueTags}
+		node := p.parseInline()
+		if _, ok := node.(*Text); ok || node == nil {
+			return 0
+		}
+
+		switch {
+		case rest[0] == '\'':
+			return countPrefix(rest, '\'')
+
+		case rest[0] == '<', strings.HasPrefix(rest, "[") && !strings.HasPrefix(rest, "[["):
+			return 1
+		}
+
+		return 2
+	}
+
+	return 0
+}
+
+// renderLine returns the wikitext of the nodes up to the end of the first line.
+func renderLine(nodes []Node) string {
+	line := ""
+	for i := range nodes {
+		line += RenderWiki(nodes[i : i+1])
+		if end := strings.IndexByte(line, '\n'); end >= 0 {
+			return line[:end]
+		}
+	}
+
+	return line
+}
+
+// lineAt returns the line of s that contains s[i] without the surrounding
+// whitespace.
+func lineAt(s string, i int) string {
+	start := strings.LastIndex(s[:i], "\n") + 1
+	end := strings.Index(s[i:], "\n")
+	if end < 0 {
+		return strings.TrimSpace(s[start:])
+	}
+
+	return strings.TrimSpace(s[start : i+end])
+}
@@ -0,0 +1,474 @@
+package wikitext
+
+import (
+	"encoding/base64"
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// htmlParser builds a document tree from the tokens of the pseudo-HTML. Only
+// the elements generated by WikiToHtml and the tags that are allowed in
+// wikitext are understood. Text is unescaped and any other tag is kept as Text
+// so that it ends up in the wikitext exactly as it was written.
+type htmlParser struct {
+	html       string
+	tokens     []htmlToken
+	pos        int
+	opaqueTags []string
+
+	// open is the names of the elements that are currently being parsed. An
+	// end tag for any of them will close all of the elements inside it.
+	open []string
+
+	// err is the first error found. Parsing continues so that the error can
+	// be reported after the whole document has been read.
+	err error
+
+	// source is the page recorded in the document.
+	source pageSource
+}
+
+var htmlElements = []string{
+	"strong", "em", "a", "img", "option", "category", "language", "template", "function", "arg", "magic", "comment", "h1", "h2", "h3", "h4", "h5",
+	"h6", "ul", "ol", "dl", "li", "dt", "dd", "oli", "table", "caption", "tr", "td", "th", "ref", "nowiki", "tag",
+	"markup", "page",
+}
+
+// ParseHtml parses pseudo-HTML that was created by WikiToHtml and may have been
+// reformatted by a CAT tool.
+func ParseHtml(html string) ([]Node, error) {
+	return parseHtml(html, defaultOpaqueTags)
+}
+
+func parseHtml(html string, opaqueTags []string) ([]Node, error) {
+	nodes, _, err := parseHtmlDocument(html, opaqueTags)
+
+	return nodes, err
+}
+
+// parseHtmlDocument is parseHtml that also returns the page that the document
+// was exported from.
+func parseHtmlDocument(html string, opaqueTags []string) ([]Node, pageSource, error) {
+	p := &htmlParser{html: html, tokens: tokenizeHtml(html), opaqueTags: opaqueTags}
+	nodes := p.parseNodes()
+
+	if p.err != nil {
+		return nil, pageSource{}, p.err
+	}
+
+	return nodes, p.source, nil
+}
+
+// fail records the first error.
+func (p *htmlParser) fail(err error, token htmlToken, cause error) {
+	if p.err == nil {
+		p.err = newError(err, p.html, token.Offset, "<"+token.Name+">", cause)
+	}
+}
+
+func (p *htmlParser) parseNodes() []Node {
+	nodes := []Node{}
+
+	for p.pos < len(p.tokens) {
+		token := p.tokens[p.pos]
+
+		switch {
+		case token.Type == htmlEndTag && contains(p.open, token.Name):
+			// Leave the end tag for the element that it belongs to.
+			return nodes
+
+		case token.Type == htmlEndTag && contains(htmlElements, token.Name):
+			// A stray end tag for an element that is not open.
+			p.pos++
+
+		case token.Type != htmlText && contains(htmlElements, token.Name):
+			p.pos++
+			if node := p.parseElement(token); node != nil {
+				nodes = appendNode(nodes, node)
+			}
+
+		case token.Type != htmlText && contains(wikiHtmlTags, token.Name):
+			p.pos++
+			nodes = appendNode(nodes, &HtmlTag{token.Name, token.Raw})
+
+		case token.Type == htmlText:
+			p.pos++
+			nodes = appendNode(nodes, &Text{html.UnescapeString(token.Raw)})
+
+		default:
+			p.pos++
+			nodes = appendNode(nodes, &Text{token.Raw})
+		}
+	}
+
+	return nodes
+}
+
+// parseChildren returns the nodes up to and including the end tag for the
+// element that was just opened.
+func (p *htmlParser) parseChildren(start htmlToken) []Node {
+	if start.Type == htmlSelfClosingTag {
+		return nil
+	}
+
+	p.open = append(p.open, start.Name)
+	children := p.parseNodes()
+	p.open = p.open[:len(p.open)-1]
+
+	if p.pos < len(p.tokens) && p.tokens[p.pos].Name == start.Name {
+		p.pos++
+	} else if start.Name == "template" || start.Name == "function" || start.Name == "arg" {
+		p.fail(ErrUnbalancedTemplate, start, nil)
+	}
+
+	return children
+}
+
+func (p *htmlParser) parseElement(start htmlToken) Node {
+	children := p.parseChildren(start)
+
+	switch start.Name {
+	case "strong":
+		_, unclosed := start.attribute("unclosed")
+		return &Bold{children, unclosed}
+
+	case "em":
+		_, unclosed := start.attribute("unclosed")
+		return &Italic{children, unclosed}
+
+	case "a":
+		href, _ := start.attribute("href")
+		return &Link{href, isAnExternalURL(href), children}
+
+	case "img":
+		namespace, ok := start.attribute("namespace")
+		if !ok {
+			namespace = "File"
+		}
+		src, _ := start.attribute("src")
+		image := &Image{Namespace: namespace, Source: src}
+
+		// Older versions had the options and link as attributes and the
+		// children were the caption.
+		if options, ok := start.attribute("options"); ok {
+			link, _ := start.attribute("link")
+			if options != "" || link != "" {
+				image.Options = append(image.Options, &ImageOption{Children: []Node{&Text{options}}, Hidden: true})
+			}
+			if link != "" {
+				image.Options = append(image.Options, &ImageOption{Children: []Node{&Text{"link=" + link}}, Hidden: true})
+			} else if len(children) > 0 {
+				image.Options = append(image.Options, &ImageOption{Children: children})
+			}
+			return image
+		}
+
+		for _, child := range children {
+			if option, ok := child.(*ImageOption); ok {
+				image.Options = append(image.Options, option)
+			}
+		}
+		return image
+
+	case "option":
+		name, _ := start.attribute("name")
+		if _, ok := start.attribute("data"); ok {
+			body, _ := p.decodePayload(start)
+			return &ImageOption{name, []Node{&Text{body}}, true}
+		}
+		return &ImageOption{name, children, false}
+
+	case "category", "language":
+		body, _ := p.decodePayload(start)
+		return parseNode(body, p.opaqueTags)
+
+	case "template":
+		name, _ := start.attribute("name")
+		template := &Template{Name: name}
+		for _, child := range children {
+			if arg, ok := child.(*Arg); ok {
+				template.Args = append(template.Args, arg)
+			}
+		}
+		return template
+
+	case "function":
+		name, _ := start.attribute("name")
+		expression, _ := p.decodePayload(start)
+		function := &ParserFunction{Name: name, Expression: expression}
+		for _, child := range children {
+			if arg, ok := child.(*Arg); ok {
+				function.Args = append(function.Args, arg)
+			}
+		}
+		return function
+
+	case "arg":
+		name, _ := start.attribute("name")
+		if _, ok := start.attribute("data"); ok {
+			body, _ := p.decodePayload(start)
+			return &Arg{name, []Node{&Text{body}}, true}
+		}
+		return &Arg{Name: name, Children: children}
+
+	case "magic":
+		value, _ := p.decodePayload(start)
+		if _, ok := start.attribute("name"); ok {
+			return &MagicWord{value, children, true}
+		}
+		return &MagicWord{Value: value}
+
+	case "comment":
+		body, _ := p.decodePayload(start)
+		_, visible := start.attribute("note")
+		return &Comment{body, visible}
+
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(start.Name[1:])
+		return &Heading{level, joinWrappedLines(trimNewLines(children))}
+
+	case "ul", "ol", "dl":
+		// Each item has already been turned into a list of the item and
+		// the items nested inside of it.
+		list := &List{}
+		for _, child := range children {
+			if items, ok := child.(*List); ok {
+				list.Items = append(list.Items, items.Items...)
+			}
+		}
+		return list
+
+	case "li", "dt", "dd", "oli":
+		parent := ""
+		if len(p.open) > 0 {
+			parent = p.open[len(p.open)-1]
+		}
+
+		_, inline := start.attribute("inline")
+		item := &ListItem{Prefix: listItemPrefix(parent, start.Name), Inline: inline && start.Name == "dd", Children: children}
+		items := flattenListItem(item)
+
+		// An implicit item only exists to hold the lists inside of it.
+		if _, implicit := start.attribute("implicit"); implicit && len(item.Children) == 0 {
+			items = items[1:]
+		}
+
+		return &List{items}
+
+	case "table":
+		attributes, _ := p.decodePayload(start)
+		table := &Table{Attributes: attributes}
+		for _, child := range children {
+			switch child := child.(type) {
+			case *TableCaption:
+				table.Caption = child
+
+			case *TableRow:
+				table.Rows = append(table.Rows, child)
+			}
+		}
+		return table
+
+	case "caption":
+		attributes, _ := p.decodePayload(start)
+		return &TableCaption{attributes, trimNewLines(children)}
+
+	case "tr":
+		attributes, _ := p.decodePayload(start)
+		_, implicit := start.attribute("implicit")
+		row := &TableRow{Attributes: attributes, Implicit: implicit}
+		for _, child := range children {
+			if cell, ok := child.(*TableCell); ok {
+				row.Cells = append(row.Cells, cell)
+			}
+		}
+		return row
+
+	case "td", "th":
+		attributes, _ := p.decodePayload(start)
+		separator, _ := start.attribute("separator")
+		if separator != "||" && separator != "!!" {
+			separator = ""
+		}
+		return &TableCell{start.Name == "th", separator, attributes, children}
+
+	case "ref":
+		p.checkPayload(start, children)
+		body, attributes := p.decodePayload(start, "selfclosing")
+		return &Ref{attributes, body, selfClosing(start, body)}
+
+	case "nowiki":
+		p.checkPayload(start, children)
+		body, attributes := p.decodePayload(start, "selfclosing")
+		return &NoWiki{attributes, body, selfClosing(start, body)}
+
+	case "tag":
+		p.checkPayload(start, children)
+		name, _ := start.attribute("name")
+		body, attributes := p.decodePayload(start, "name", "selfclosing")
+		return &Tag{name, attributes, body, selfClosing(start, body)}
+
+	case "markup":
+		raw, _ := p.decodePayload(start)
+		token, _ := readHtmlTag(raw)
+		return &HtmlTag{token.Name, raw}
+
+	case "page":
+		language, _ := start.attribute("language")
+		p.source = newPageSource(language, p.decode(start, "data"))
+	}
+
+	return nil
+}
+
+// selfClosing returns true if a <ref>, <nowiki> or opaque tag was written as
+// <ref />. Files created before this was recorded only have self-closing tags
+// when there is no body.
+func selfClosing(start htmlToken, body string) bool {
+	if value, ok := start.attribute("selfclosing"); ok {
+		return value == "true" && body == ""
+	}
+
+	return body == ""
+}
+
+// listItemPrefix returns the wikitext for an item element inside of a list
+// element. Older versions had a numbered item (<oli>) instead of lists.
+func listItemPrefix(listName, itemName string) string {
+	switch {
+	case itemName == "dt":
+		return ";"
+	case itemName == "dd":
+		return ":"
+	case listName == "ol", itemName == "oli":
+		return "#"
+	}
+
+	return "*"
+}
+
+// flattenListItem returns the item followed by the items of any lists inside of
+// it.
+func flattenListItem(item *ListItem) []*ListItem {
+	items := []*ListItem{item}
+	children := []Node{}
+	for _, child := range item.Children {
+		list, ok := child.(*List)
+		if !ok {
+			children = appendNode(children, child)
+			continue
+		}
+
+		for _, nested := range list.Items {
+			nested.Prefix = item.Prefix + nested.Prefix
+			items = append(items, nested)
+		}
+	}
+	item.Children = joinWrappedLines(trimNewLines(children))
+
+	return items
+}
+
+// trimNewLines removes the white space that a CAT tool may have added to the
+// start or end of a heading, list item or caption. A new line would end the
+// heading or item in the wikitext.
+func trimNewLines(nodes []Node) []Node {
+	if len(nodes) == 0 {
+		return nodes
+	}
+
+	if text, ok := nodes[0].(*Text); ok {
+		if trimmed := strings.TrimLeft(text.Value, " \t\r\n"); strings.Contains(text.Value[:len(text.Value)-len(trimmed)], "\n") {
+			text.Value = trimmed
+		}
+	}
+
+	if text, ok := nodes[len(nodes)-1].(*Text); ok {
+		if trimmed := strings.TrimRight(text.Value, " \t\r\n"); strings.Contains(text.Value[len(trimmed):], "\n") {
+			text.Value = trimmed
+		}
+	}
+
+	return nodes
+}
+
+// joinWrappedLines puts the text of a heading or list item that a CAT tool has
+// wrapped back on one line. A caption can have more than one line so it is
+// left alone.
+func joinWrappedLines(nodes []Node) []Node {
+	walkNodes(nodes, func(node Node) bool {
+		switch n := node.(type) {
+		case *Text:
+			n.Value = joinLines(n.Value)
+
+		case *Bold, *Italic, *Link:
+			return true
+		}
+
+		return false
+	})
+
+	return nodes
+}
+
+// joinLines replaces each new line, and the white space around it, with a
+// space.
+func joinLines(s string) string {
+	if !strings.Contains(s, "\n") {
+		return s
+	}
+
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		if i > 0 {
+			lines[i] = strings.TrimLeft(lines[i], " \t\r")
+		}
+		if i < len(lines)-1 {
+			lines[i] = strings.TrimRight(lines[i], " \t\r")
+		}
+	}
+
+	return strings.Join(lines, " ")
+}
+
+// decodePayload returns the content hidden in the data attribute and the
+// attributes that were on the original tag. Files created before the
+// attributes were encoded have them on the element itself (except for the
+// names in ignore).
+func (p *htmlParser) decodePayload(start htmlToken, ignore ...string) (body, attributes string) {
+	body = p.decode(start, "data")
+
+	if _, ok := start.attribute("attributes"); ok {
+		return body, p.decode(start, "attributes")
+	}
+
+	return body, start.rawAttributes(append(ignore, "data")...)
+}
+
+// checkPayload fails if a <ref>, <nowiki> or opaque tag has content of its own
+// instead of a data attribute. The content would otherwise be lost.
+func (p *htmlParser) checkPayload(start htmlToken, children []Node) {
+	if _, ok := start.attribute("data"); ok {
+		return
+	}
+
+	for _, child := range children {
+		if text, ok := child.(*Text); !ok || strings.TrimSpace(text.Value) != "" {
+			p.fail(ErrCorruptPayload, start, errors.New("the content is not in the data attribute"))
+			return
+		}
+	}
+}
+
+// decode returns the content hidden in an attribute.
+func (p *htmlParser) decode(start htmlToken, name string) string {
+	data, _ := start.attribute(name)
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		p.fail(ErrCorruptPayload, start, err)
+	}
+
+	return string(decoded)
+}
@@ -0,0 +1,121 @@
+package wikitext
+
+import (
+	"reflect"
+	"testing"
+)
+
+type htmlToWikiExample struct {
+	name string
+	html string
+	wiki string
+}
+
+// These are the kinds of changes that CAT tools make to the HTML when it is
+// exported after being translated.
+var htmlToWikiExamples = []htmlToWikiExample{
+	// Quoting
+	{"q101", `foo <a href='Bar'>some label</a> baz`, "foo [[Bar|some label]] baz"},
+	{"q102", `foo <a href=Bar>some label</a> baz`, "foo [[Bar|some label]] baz"},
+	{"q103", `foo <template name='bar'><arg name='qux'>abc</arg></template> baz`, "foo {{bar|qux=abc}} baz"},
+
+	// Attribute order
+	{"q201",
+		`foo <img link="Internal" options="options" src="filename.extension"></img> baz`,
+		"foo [[File:filename.extension|options|link=Internal]] baz"},
+	{"q204", "<li>foo</li>\n<oli>bar</oli>\n<oli>baz</oli>", "*foo\n#bar\n#baz"},
+	{"q203",
+		"<img src=\"x.jpg\" namespace=\"Image\">\n  <option data=\"dGh1bWI=\"></option>\n  <option name=\"\">caption</option>\n</img>",
+		"[[Image:x.jpg|thumb|caption]]"},
+	{"q202",
+		`foo <ref name="qux" data="W1tBQkNdXQ=="></ref> baz`,
+		`foo <ref name="qux">[[ABC]]</ref> baz`},
+
+	// Extra attributes
+	{"q301", `foo <a xml:lang="fr" href="Bar">Bar</a> baz`, "foo [[Bar]] baz"},
+	{"q302",
+		`foo <ref data="W1tBQkNdXQ==" xml:lang="fr" name="qux"></ref> baz`,
+		`foo <ref name="qux">[[ABC]]</ref> baz`},
+
+	// Whitespace
+	{"q401", "foo <a\n  href=\"Bar\"\n>some label</a> baz", "foo [[Bar|some label]] baz"},
+	{"q402", "foo <template name = \"bar\">\n  <arg name=\"\">qux</arg>\n</template> baz", "foo {{bar|qux}} baz"},
+	{"q403", "foo <nowiki\ndata=\"JydxdXgnJw==\"></nowiki> baz", "foo <nowiki>''qux''</nowiki> baz"},
+	{"q404", "<ul>\n  <li>foo\n    <ul>\n      <li>bar</li>\n    </ul></li>\n  <li>baz</li>\n</ul>", "*foo\n**bar\n*baz"},
+	{"q405", "<ol><li>\n  foo\n</li></ol>", "#foo"},
+	{"q406", "<ul>\n  <li>A long item that\n    was <strong>wrapped\n    twice</strong></li>\n</ul>", "*A long item that was '''wrapped twice'''"},
+	{"q407", "<h2> A long\n  heading </h2>\n<table>\n<caption>\n  A caption\n</caption>\n</table>", "== A long heading ==\n{|\n|+A caption\n|}"},
+
+	// Entities
+	{"q501", `foo <a href="Bar &amp; Baz">label</a> baz`, "foo [[Bar & Baz|label]] baz"},
+	{"q502", `foo <template name="bar&#39;s"></template> baz`, "foo {{bar's}} baz"},
+
+	// Case and self-closing tags
+	{"q601", `foo <STRONG>bar</STRONG> baz`, "foo '''bar''' baz"},
+	{"q604", "<strong>foo\nbar</strong>", "'''foo'''\n'''bar'''"},
+	{"q605", `<em>a <strong>b</strong></em><strong> c</strong>`, "''a '''b'' c'''"},
+	{"q607", `<strong unclosed="true">a</strong> b <em unclosed="true">c</em>`, "'''a''' b ''c"},
+	{"q602", `foo <ref data="" name="qux"/> baz`, `foo <ref name="qux"/> baz`},
+	{"q603", `foo <template name="bar"/> baz`, "foo {{bar}} baz"},
+	{"q606", `<ref data="" attributes="IG5hbWU9ImEi" selfclosing="false"/> <nowiki data="" selfclosing="true"></nowiki>`, `<ref name="a"></ref> <nowiki/>`},
+
+	// Tags that are not ours are left alone
+	{"q701", `foo <br/> <small>bar</small> baz`, `foo <br/> <small>bar</small> baz`},
+	{"q702", `foo < bar > baz`, `foo < bar > baz`},
+	{"q703", `a &lt;b&gt; &amp;amp; &quot;c&quot;`, `a &lt;b> &amp; "c"`},
+	{"q704", `a&#160;b&nbsp;c`, "a\u00a0b\u00a0c"},
+	{"q705", `<sup>a</sup> <markup data="PHN0cm9uZz4="></markup>`, `<sup>a</sup> <strong>`},
+
+	// Unbalanced tags
+	{"q801", `foo <strong><em>bar</strong> baz`, "foo '''''bar''''' baz"},
+	{"q802", `foo bar</em> baz`, "foo bar baz"},
+	{"q803", "<li>foo</li>\n<li>bar</li>", "*foo\n*bar"},
+	{"q804", "<table>\n<tr>\n<td separator=\"||\">a</td>\n<td separator=\"x\">b</td>\n</tr>\n</table>", "{|\n|-\n|a\n|b\n|}"},
+
+	// Wiki markup typed by translators
+	{"q901", `See [[Foo]] and {{bar}}`, `See <nowiki>[[</nowiki>Foo]] and <nowiki>{{</nowiki>bar}}`},
+	{"q902", `Signed ~~~~`, `Signed <nowiki>~~~~</nowiki>`},
+	{"q903", `''quoted'' '''`, `<nowiki>''</nowiki>quoted<nowiki>''</nowiki> <nowiki>'''</nowiki>`},
+	{"q904", "* a\n# b\n== c ==\n:d", "<nowiki>*</nowiki> a\n<nowiki>#</nowiki> b\n<nowiki>=</nowiki>= c ==\n<nowiki>:</nowiki>d"},
+	{"q905", `<template name="T"><arg name="">a|b}}</arg><arg name="">x=y</arg><arg name="z">1=2</arg></template>`, `{{T|a<nowiki>|</nowiki>b<nowiki>}}</nowiki>|x<nowiki>=</nowiki>y|z=1=2}}`},
+	{"q906", `<a href="Foo">a]]b|c</a> <a href="http://x.org">d]e</a>`, `[[Foo|a<nowiki>]]</nowiki>b|c]] [http://x.org d<nowiki>]</nowiki>e]`},
+	{"q907", "<table>\n<tr>\n<td>a || b</td>\n<td>c | d\n* e\n| f</td>\n</tr>\n</table>", "{|\n|-\n|a <nowiki>||</nowiki> b\n|c <nowiki>|</nowiki> d\n<nowiki>*</nowiki> e\n<nowiki>|</nowiki> f\n|}"},
+	{"q908", `a &lt;ref&gt;b&lt;/ref&gt; __NOTOC__ [http://x.org y]`, `a &lt;ref>b</ref> <nowiki>__</nowiki>NOTOC__ <nowiki>[</nowiki>http://x.org y]`},
+	{"q909", `a [[ b | c ~~ d &lt; e`, `a [[ b | c ~~ d < e`},
+	{"q910", `~~1~~ ~~~~~~ <magic data="fn5+fg=="></magic>`, `~~1~~ <nowiki>~~~~~~</nowiki> ~~~~`},
+	{"q911", `<ref xml:lang="fr" attributes="IG5hbWU9ImImYW1wO2Mi" data="ZA=="></ref>`, `<ref name="b&amp;c">d</ref>`},
+	{"q912", `<em>'quoted'</em> d'<em>'a'</em> <strong>''b</strong>''`, `''<nowiki>'</nowiki>quoted<nowiki>'</nowiki>'' d<nowiki>'</nowiki>''<nowiki>'</nowiki>a<nowiki>'</nowiki>'' '''<nowiki>''</nowiki>b'''<nowiki>''</nowiki>`},
+	{"q913", `<em>it's</em> <strong>a</strong>'s <strong>a'</strong>s`, `''it's'' '''a'''<nowiki>'</nowiki>s '''a''''s`},
+	{"q914", `<dl><dt>Time: 10:00</dt><dd inline="true">x</dd></dl>`, ";Time<nowiki>:</nowiki> 10<nowiki>:</nowiki>00:x"},
+	{"q915", "<ol><li>#a</li><li>*b</li><li>:c</li><li>;d</li><li> #e</li><li><strong>#f</strong></li></ol>", "#<nowiki>#</nowiki>a\n#<nowiki>*</nowiki>b\n#<nowiki>:</nowiki>c\n#<nowiki>;</nowiki>d\n# #e\n#'''#f'''"},
+	{"q916", `<dl><dt>a <a href="b:c">d:e</a> <em>f:g</em></dt></dl>`, ";a [[b:c|d:e]] ''f<nowiki>:</nowiki>g''"},
+	{"q917", `<template name="T"><arg name="">2+2=4</arg><arg name="">Q&amp;A = x = y</arg><arg name=""><strong>a=b</strong></arg><arg name="">a <a href="b">c=d</a></arg></template>`, "{{T|2+2<nowiki>=</nowiki>4|Q&A <nowiki>=</nowiki> x <nowiki>=</nowiki> y|'''a<nowiki>=</nowiki>b'''|a [[b|c=d]]}}"},
+	{"q919", `== a == <comment data="eA=="></comment>`, "<nowiki>=</nowiki>= a == <!--x-->"},
+	{"q918", `<function name="#if" data="eA=="><arg name="">a=b</arg></function> <function name="#switch" data="eA=="><arg name="a">b=c</arg><arg name="">d=e</arg></function>`, "{{#if:x|a=b}} {{#switch:x|a=b=c|d<nowiki>=</nowiki>e}}"},
+}
+
+func TestHtmlToWiki(t *testing.T) {
+	for _, test := range htmlToWikiExamples {
+		wiki, err := HtmlToWiki(test.html)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if wiki != test.wiki {
+			t.Errorf("%v:\n  expected wiki: '%v'\n      from HTML: '%v'\n            got: '%v'\n\n",
+				test.name, test.wiki, test.html, wiki)
+		}
+	}
+}
+
+func TestHtmlTagNameRoundTrip(t *testing.T) {
+	tag := &Tag{Name: `a"b&c`, Body: "x"}
+	html := RenderHtml([]Node{tag})
+
+	nodes, err := ParseHtml(html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || !reflect.DeepEqual(nodes[0], tag) {
+		t.Errorf("Expected %v from '%v', got %v", tag, html, nodes)
+	}
+}
@@ -0,0 +1,229 @@
+package wikitext
+
+import (
+	"html"
+	"strings"
+)
+
+type htmlTokenType int
+
+const (
+	htmlText htmlTokenType = iota
+	htmlStartTag
+	htmlEndTag
+	htmlSelfClosingTag
+)
+
+// htmlAttribute is a single attribute of a tag. Value has been unquoted and
+// unescaped. Raw is the original source of the attribute, including the
+// whitespace that came before it.
+type htmlAttribute struct {
+	Name  string
+	Value string
+	Raw   string
+}
+
+// htmlToken is a piece of the pseudo-HTML. Name is always lowercase and Raw is
+// the exact source of the token so that anything that is not recognised can be
+// passed through unchanged. Offset is where Raw starts in the HTML.
+type htmlToken struct {
+	Type       htmlTokenType
+	Name       string
+	Attributes []htmlAttribute
+	Trailing   string
+	Raw        string
+	Offset     int
+}
+
+func (t htmlToken) attribute(name string) (string, bool) {
+	for _, attribute := range t.Attributes {
+		if attribute.Name == name {
+			return attribute.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// rawAttributes returns the original source of all the attributes except the
+// ones listed. Attributes that belong to an XML namespace (like xml:lang) are
+// also removed as they are added by CAT tools and were never in the wikitext.
+func (t htmlToken) rawAttributes(except ...string) string {
+	result := ""
+
+	for _, attribute := range t.Attributes {
+		if strings.HasPrefix(attribute.Name, "xml") || contains(except, attribute.Name) {
+			continue
+		}
+
+		result += attribute.Raw
+	}
+
+	return result + t.Trailing
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isTagNameChar(c byte) bool {
+	return isLetter(c) || (c >= '0' && c <= '9') || c == ':' || c == '-' || c == '_'
+}
+
+// tokenizeHtml splits HTML into text and tags. It is forgiving in the same way
+// browsers are: attributes can be in any order, use either quote style (or none)
+// and be spread over several lines.
+func tokenizeHtml(s string) []htmlToken {
+	tokens := []htmlToken{}
+	text := ""
+	textStart := 0
+
+	for i := 0; i < len(s); {
+		if text == "" {
+			textStart = i
+		}
+
+		if s[i] == '<' {
+			if strings.HasPrefix(s[i:], "<!--") {
+				end := strings.Index(s[i:], "-->")
+				if end < 0 {
+					end = len(s) - i
+				} else {
+					end += 3
+				}
+
+				text += s[i : i+end]
+				i += end
+				continue
+			}
+
+			if token, ok := readHtmlTag(s[i:]); ok {
+				if text != "" {
+					tokens = append(tokens, htmlToken{Type: htmlText, Raw: text, Offset: textStart})
+					text = ""
+				}
+
+				token.Offset = i
+				tokens = append(tokens, token)
+				i += len(token.Raw)
+				continue
+			}
+		}
+
+		text += s[i : i+1]
+		i++
+	}
+
+	if text != "" {
+		tokens = append(tokens, htmlToken{Type: htmlText, Raw: text, Offset: textStart})
+	}
+
+	return tokens
+}
+
+// readHtmlTag reads a single tag from the start of s. ok will be false if s does
+// not start with something that looks like a tag.
+func readHtmlTag(s string) (token htmlToken, ok bool) {
+	i := 1
+	token.Type = htmlStartTag
+	if i < len(s) && s[i] == '/' {
+		token.Type = htmlEndTag
+		i++
+	}
+
+	nameStart := i
+	for i < len(s) && isTagNameChar(s[i]) {
+		i++
+	}
+
+	if i == nameStart || !isLetter(s[nameStart]) {
+		return
+	}
+	token.Name = strings.ToLower(s[nameStart:i])
+
+	for {
+		start := i
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+
+		if i >= len(s) {
+			return
+		}
+
+		if s[i] == '>' {
+			token.Trailing = s[start:i]
+			token.Raw = s[:i+1]
+			return token, true
+		}
+
+		if strings.HasPrefix(s[i:], "/>") {
+			if token.Type == htmlStartTag {
+				token.Type = htmlSelfClosingTag
+			}
+			token.Trailing = s[start:i]
+			token.Raw = s[:i+2]
+			return token, true
+		}
+
+		nameStart := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && !strings.HasPrefix(s[i:], "/>") {
+			i++
+		}
+		if i == nameStart {
+			// A stray character such as a lone "=".
+			i++
+		}
+		attribute := htmlAttribute{Name: strings.ToLower(s[nameStart:i])}
+
+		valueStart := i
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				end := strings.IndexByte(s[i+1:], s[i])
+				if end < 0 {
+					return
+				}
+				attribute.Value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				valueStart := i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				attribute.Value = s[valueStart:i]
+			}
+
+			attribute.Value = html.UnescapeString(attribute.Value)
+		} else {
+			// There was no value so the whitespace belongs to whatever comes
+			// next.
+			i = valueStart
+		}
+
+		attribute.Raw = s[start:i]
+		token.Attributes = append(token.Attributes, attribute)
+	}
+}
@@ -0,0 +1,369 @@
+package wikitext
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RenderHtml converts a parsed wikitext document into the pseudo-HTML that is
+// given to the CAT tools.
+func RenderHtml(nodes []Node) string {
+	buf := new(bytes.Buffer)
+	writeHtmlNodes(buf, nodes)
+
+	return buf.String()
+}
+
+func writeHtmlNodes(buf *bytes.Buffer, nodes []Node) {
+	balanced := balancedHtmlTags(nodes)
+
+	for _, node := range nodes {
+		if tag, ok := node.(*HtmlTag); ok && balanced[tag] {
+			buf.WriteString(tag.Raw)
+			continue
+		}
+
+		writeHtmlNode(buf, node)
+	}
+}
+
+// htmlVoidElements are the tags that never have an end tag.
+var htmlVoidElements = []string{"br", "hr", "wbr"}
+
+// balancedHtmlTags finds the HTML tags that can be written as they are. That
+// is a void or self-closing tag, or a start and end tag that are both in nodes
+// and properly nested. Tags that would be mistaken for the elements that
+// WikiToHtml creates (such as <strong>) are never included.
+func balancedHtmlTags(nodes []Node) map[*HtmlTag]bool {
+	balanced := map[*HtmlTag]bool{}
+	open := []*HtmlTag{}
+
+	for _, node := range nodes {
+		tag, ok := node.(*HtmlTag)
+		if !ok || contains(htmlElements, tag.Name) {
+			continue
+		}
+
+		token, _ := readHtmlTag(tag.Raw)
+		switch {
+		case token.Type == htmlSelfClosingTag || contains(htmlVoidElements, tag.Name):
+			balanced[tag] = true
+
+		case token.Type == htmlStartTag:
+			open = append(open, tag)
+
+		case len(open) > 0 && open[len(open)-1].Name == tag.Name:
+			balanced[tag] = true
+			balanced[open[len(open)-1]] = true
+			open = open[:len(open)-1]
+
+		default:
+			// A stray end tag means that nothing that is open can be closed
+			// properly.
+			open = nil
+		}
+	}
+
+	return balanced
+}
+
+func writeHtmlNode(buf *bytes.Buffer, node Node) {
+	switch n := node.(type) {
+	case *Text:
+		xmlEscaper.WriteString(buf, n.Value)
+
+	case *Bold:
+		fmt.Fprintf(buf, "<strong%v>", htmlUnclosed(n.Unclosed))
+		writeHtmlNodes(buf, n.Children)
+		buf.WriteString("</strong>")
+
+	case *Italic:
+		fmt.Fprintf(buf, "<em%v>", htmlUnclosed(n.Unclosed))
+		writeHtmlNodes(buf, n.Children)
+		buf.WriteString("</em>")
+
+	case *Link:
+		fmt.Fprintf(buf, `<a href="%v">`, xmlAttributeEscaper.Replace(n.Target))
+		writeHtmlNodes(buf, n.Children)
+		buf.WriteString("</a>")
+
+	case *Image:
+		fmt.Fprintf(buf, `<img namespace="%v" src="%v">`,
+			xmlAttributeEscaper.Replace(n.Namespace), xmlAttributeEscaper.Replace(n.Source))
+		for _, option := range n.Options {
+			writeHtmlNode(buf, option)
+		}
+		buf.WriteString("</img>")
+
+	case *ImageOption:
+		if n.Hidden {
+			fmt.Fprintf(buf, `<option data="%v"></option>`, encodePayload(RenderWiki(n.Children)))
+			return
+		}
+
+		fmt.Fprintf(buf, `<option name="%v">`, xmlAttributeEscaper.Replace(n.Name))
+		writeHtmlNodes(buf, n.Children)
+		buf.WriteString("</option>")
+
+	case *Category:
+		fmt.Fprintf(buf, `<category data="%v"></category>`, encodePayload(RenderWiki([]Node{n})))
+
+	case *LanguageLink:
+		fmt.Fprintf(buf, `<language data="%v"></language>`, encodePayload(RenderWiki([]Node{n})))
+
+	case *Template:
+		fmt.Fprintf(buf, `<template name="%v">`, xmlAttributeEscaper.Replace(n.Name))
+		for _, arg := range n.Args {
+			writeHtmlNode(buf, arg)
+		}
+		buf.WriteString("</template>")
+
+	case *ParserFunction:
+		fmt.Fprintf(buf, `<function name="%v" data="%v">`, xmlAttributeEscaper.Replace(n.Name), encodePayload(n.Expression))
+		for _, arg := range n.Args {
+			writeHtmlNode(buf, arg)
+		}
+		buf.WriteString("</function>")
+
+	case *Arg:
+		if n.Hidden {
+			fmt.Fprintf(buf, `<arg name="%v" data="%v"></arg>`, xmlAttributeEscaper.Replace(n.Name), encodePayload(RenderWiki(n.Children)))
+			return
+		}
+
+		fmt.Fprintf(buf, `<arg name="%v">`, xmlAttributeEscaper.Replace(n.Name))
+		writeHtmlNodes(buf, n.Children)
+		buf.WriteString("</arg>")
+
+	case *MagicWord:
+		if !n.Translatable {
+			fmt.Fprintf(buf, `<magic data="%v"></magic>`, encodePayload(n.Value))
+			return
+		}
+
+		fmt.Fprintf(buf, `<magic name="DISPLAYTITLE" data="%v">`, encodePayload(n.Value))
+		writeHtmlNodes(buf, n.Children)
+		buf.WriteString("</magic>")
+
+	case *Comment:
+		if n.Visible {
+			fmt.Fprintf(buf, `<comment data="%v" note="%v"></comment>`,
+				encodePayload(n.Body), xmlAttributeEscaper.Replace(n.Body))
+			return
+		}
+
+		fmt.Fprintf(buf, `<comment data="%v"></comment>`, encodePayload(n.Body))
+
+	case *Heading:
+		fmt.Fprintf(buf, "<h%d>", n.Level)
+		writeHtmlNodes(buf, n.Children)
+		fmt.Fprintf(buf, "</h%d>", n.Level)
+
+	case *List:
+		writeHtmlList(buf, n)
+
+	case *Table:
+		fmt.Fprintf(buf, "<table%v>\n", htmlTableAttributes(n.Attributes))
+		if n.Caption != nil {
+			writeHtmlNode(buf, n.Caption)
+		}
+		for _, row := range n.Rows {
+			writeHtmlNode(buf, row)
+		}
+		buf.WriteString("</table>")
+
+	case *TableCaption:
+		fmt.Fprintf(buf, "<caption%v>", htmlTableAttributes(n.Attributes))
+		writeHtmlNodes(buf, n.Children)
+		buf.WriteString("</caption>\n")
+
+	case *TableRow:
+		implicit := ""
+		if n.Implicit {
+			implicit = ` implicit="true"`
+		}
+		fmt.Fprintf(buf, "<tr%v%v>\n", htmlTableAttributes(n.Attributes), implicit)
+		for _, cell := range n.Cells {
+			writeHtmlNode(buf, cell)
+		}
+		buf.WriteString("</tr>\n")
+
+	case *TableCell:
+		tag := "td"
+		if n.Header {
+			tag = "th"
+		}
+		separator := ""
+		if n.Separator != "" {
+			separator = fmt.Sprintf(` separator="%v"`, n.Separator)
+		}
+		fmt.Fprintf(buf, "<%v%v%v>", tag, htmlTableAttributes(n.Attributes), separator)
+		writeHtmlNodes(buf, n.Children)
+		fmt.Fprintf(buf, "</%v>\n", tag)
+
+	case *Ref:
+		fmt.Fprintf(buf, `<ref data="%v"%v%v></ref>`, encodePayload(n.Body), htmlTagAttributes(n.Attributes),
+			htmlSelfClosing(n.Body, n.SelfClosing))
+
+	case *NoWiki:
+		fmt.Fprintf(buf, `<nowiki data="%v"%v%v></nowiki>`, encodePayload(n.Body), htmlTagAttributes(n.Attributes),
+			htmlSelfClosing(n.Body, n.SelfClosing))
+
+	case *Tag:
+		fmt.Fprintf(buf, `<tag name="%v" data="%v"%v%v></tag>`, xmlAttributeEscaper.Replace(n.Name), encodePayload(n.Body), htmlTagAttributes(n.Attributes),
+			htmlSelfClosing(n.Body, n.SelfClosing))
+
+	case *HtmlTag:
+		// Only the balanced tags are written as they are (see writeHtmlNodes).
+		// Any other tag would confuse the CAT tool so it is hidden.
+		fmt.Fprintf(buf, `<markup data="%v"></markup>`, encodePayload(n.Raw))
+	}
+}
+
+// writeHtmlList nests the items of a list in the same way as MediaWiki. An item
+// that has a longer prefix than the item before it starts a new list inside of
+// that item.
+func writeHtmlList(buf *bytes.Buffer, list *List) {
+	// open has a character for each list that has been opened. It is the
+	// type of the current item in that list.
+	open := ""
+
+	for _, item := range list.Items {
+		common := 0
+		for common < len(open) && common < len(item.Prefix) &&
+			listType(open[common]) == listType(item.Prefix[common]) {
+			common++
+		}
+
+		for len(open) > common {
+			c := open[len(open)-1]
+			fmt.Fprintf(buf, "</%v></%v>", listItemTag(c), listTag(c))
+			open = open[:len(open)-1]
+		}
+
+		if common > 0 && common == len(item.Prefix) {
+			// The next item in the same list.
+			fmt.Fprintf(buf, "</%v>", listItemTag(open[common-1]))
+			if !item.Inline {
+				buf.WriteString("\n")
+			}
+			open = open[:common-1]
+		}
+
+		for len(open) < len(item.Prefix) {
+			c := item.Prefix[len(open)]
+			if len(open) >= common {
+				fmt.Fprintf(buf, "<%v>", listTag(c))
+			}
+			switch {
+			case len(open) < len(item.Prefix)-1:
+				// There is no line for the items that only hold a list.
+				fmt.Fprintf(buf, `<%v implicit="true">`, listItemTag(c))
+			case item.Inline:
+				fmt.Fprintf(buf, `<%v inline="true">`, listItemTag(c))
+			default:
+				fmt.Fprintf(buf, "<%v>", listItemTag(c))
+			}
+			open += string(c)
+		}
+
+		writeHtmlNodes(buf, item.Children)
+	}
+
+	for len(open) > 0 {
+		c := open[len(open)-1]
+		fmt.Fprintf(buf, "</%v></%v>", listItemTag(c), listTag(c))
+		open = open[:len(open)-1]
+	}
+}
+
+func listTag(c byte) string {
+	switch c {
+	case '*':
+		return "ul"
+	case '#':
+		return "ol"
+	}
+
+	return "dl"
+}
+
+func listItemTag(c byte) string {
+	switch c {
+	case ';':
+		return "dt"
+	case ':':
+		return "dd"
+	}
+
+	return "li"
+}
+
+// encodePayload hides content from translators.
+func encodePayload(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// htmlPageSource records the page that the document was exported from. The
+// title is hidden so that it is not translated.
+func htmlPageSource(source pageSource) string {
+	if source.Title == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(`<page language="%v" data="%v"></page>`,
+		xmlAttributeEscaper.Replace(source.Language), encodePayload(source.Title))
+}
+
+// htmlTagAttributes hides the attributes of a <ref>, <nowiki> or opaque tag.
+// They are wikitext rather than HTML (they may not be quoted or may contain a
+// "<") so they are encoded like the body to be put back exactly as they were.
+func htmlTagAttributes(attributes string) string {
+	if attributes == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(` attributes="%v"`, encodePayload(attributes))
+}
+
+// htmlUnclosed records that bold or italic was closed by the end of the line.
+func htmlUnclosed(unclosed bool) string {
+	if !unclosed {
+		return ""
+	}
+
+	return ` unclosed="true"`
+}
+
+// htmlSelfClosing records whether a <ref>, <nowiki> or opaque tag without a
+// body was written as <ref /> or <ref></ref>. The element itself can not be
+// used because CAT tools do not keep the difference.
+func htmlSelfClosing(body string, selfClosing bool) string {
+	if body != "" {
+		return ""
+	}
+
+	return fmt.Sprintf(` selfclosing="%v"`, selfClosing)
+}
+
+// tableSpanRegexp finds the rowspan and colspan in the attributes of a cell.
+var tableSpanRegexp = regexp.MustCompile(`(?i)\b(rowspan|colspan)\s*=\s*["']?(\d+)`)
+
+// htmlTableAttributes hides the attributes of a table, row, cell or caption in
+// the payload so that they are put back exactly as they were. The rowspan and
+// colspan are also copied so that the cells are laid out correctly.
+func htmlTableAttributes(attributes string) string {
+	if attributes == "" {
+		return ""
+	}
+
+	s := fmt.Sprintf(` data="%v"`, encodePayload(attributes))
+	for _, match := range tableSpanRegexp.FindAllStringSubmatch(attributes, -1) {
+		s += fmt.Sprintf(` %v="%v"`, strings.ToLower(match[1]), match[2])
+	}
+
+	return s
+}
@@ -0,0 +1,391 @@
+package wikitext
+
+import (
+	"fmt"
+	"strings"
+)
+
+// inlineCode describes a node as the wikitext around its translatable
+// children. It is used by the formats (like XLIFF) that represent markup as
+// codes inside of the translatable text rather than as HTML elements.
+//
+// If the node has no translatable content Paired will be false and Start will
+// be the whole node as wikitext.
+//
+// Note is text that is shown to translators but can not be translated, such as
+// a visible comment.
+//
+// Unclosed is true for bold or italic that was closed by the end of the line.
+// End is empty because there are no apostrophes to close it.
+type inlineCode struct {
+	Kind     string
+	Start    string
+	End      string
+	Children []Node
+	Paired   bool
+	Note     string
+	Unclosed bool
+}
+
+func pairedCode(kind, start, end string, children []Node) inlineCode {
+	return inlineCode{Kind: kind, Start: start, End: end, Children: children, Paired: true}
+}
+
+func unclosedCode(kind, start string, children []Node) inlineCode {
+	code := pairedCode(kind, start, "", children)
+	code.Unclosed = true
+	return code
+}
+
+func unpairedCode(kind string, node Node) inlineCode {
+	return inlineCode{Kind: kind, Start: RenderWiki([]Node{node})}
+}
+
+// codeForNode returns the inline code for any node except Text.
+func codeForNode(node Node) inlineCode {
+	switch n := node.(type) {
+	case *Bold:
+		if n.Unclosed {
+			return unclosedCode("bold", "'''", n.Children)
+		}
+		return pairedCode("bold", "'''", "'''", n.Children)
+
+	case *Italic:
+		if n.Unclosed {
+			return unclosedCode("italic", "''", n.Children)
+		}
+		return pairedCode("italic", "''", "''", n.Children)
+
+	case *Link:
+		if len(n.Children) == 0 {
+			return unpairedCode("link", n)
+		}
+		if n.External {
+			return pairedCode("link", "["+n.Target+" ", "]", n.Children)
+		}
+		return pairedCode("link", "[["+n.Target+"|", "]]", n.Children)
+
+	case *Image:
+		options := []Node{}
+		translatable := false
+		for _, option := range n.Options {
+			options = append(options, option)
+			translatable = translatable || !option.Hidden
+		}
+		if !translatable {
+			return unpairedCode("image", n)
+		}
+		return pairedCode("image", "[["+n.Namespace+":"+n.Source, "]]", options)
+
+	case *ImageOption:
+		if n.Hidden {
+			return unpairedCode("option", n)
+		}
+		if n.Name == "" {
+			return pairedCode("option", "|", "", n.Children)
+		}
+		return pairedCode("option", "|"+n.Name+"=", "", n.Children)
+
+	case *Category:
+		return unpairedCode("category", n)
+
+	case *LanguageLink:
+		return unpairedCode("language", n)
+
+	case *Template:
+		if len(n.Args) == 0 {
+			return unpairedCode("template", n)
+		}
+		args := []Node{}
+		for _, arg := range n.Args {
+			args = append(args, arg)
+		}
+		return pairedCode("template", "{{"+n.Name, "}}", args)
+
+	case *ParserFunction:
+		if len(n.Args) == 0 {
+			return unpairedCode("function", n)
+		}
+		args := []Node{}
+		for _, arg := range n.Args {
+			args = append(args, arg)
+		}
+		return pairedCode("function", "{{"+n.Name+":"+n.Expression, "}}", args)
+
+	case *Arg:
+		if n.Hidden {
+			return unpairedCode("arg", n)
+		}
+		if n.Name == "" {
+			return pairedCode("arg", "|", "", n.Children)
+		}
+		return pairedCode("arg", "|"+n.Name+"=", "", n.Children)
+
+	case *MagicWord:
+		if !n.Translatable {
+			return unpairedCode("magic", n)
+		}
+		return pairedCode("magic", n.Value, "}}", n.Children)
+
+	case *Comment:
+		code := unpairedCode("comment", n)
+		if n.Visible {
+			code.Note = n.Body
+		}
+		return code
+
+	case *Heading:
+		equals := strings.Repeat("=", n.Level)
+		return pairedCode("heading", equals, equals, n.Children)
+
+	case *List:
+		items := []Node{}
+		for _, item := range n.Items {
+			items = append(items, item)
+		}
+		return pairedCode("list", "", "", items)
+
+	case *ListItem:
+		if n.Inline {
+			return pairedCode("definition", ":", "", n.Children)
+		}
+		return pairedCode("item", n.Prefix, "", n.Children)
+
+	case *Table:
+		children := []Node{}
+		if n.Caption != nil {
+			children = append(children, n.Caption)
+		}
+		for _, row := range n.Rows {
+			children = append(children, row)
+		}
+		return pairedCode("table", "{|"+n.Attributes, "|}", children)
+
+	case *TableCaption:
+		start := "|+"
+		if n.Attributes != "" {
+			start += n.Attributes + "|"
+		}
+		return pairedCode("caption", start, "", n.Children)
+
+	case *TableRow:
+		cells := []Node{}
+		for _, cell := range n.Cells {
+			cells = append(cells, cell)
+		}
+		if n.Implicit {
+			return pairedCode("row", "", "", cells)
+		}
+		return pairedCode("row", "|-"+n.Attributes, "", cells)
+
+	case *TableCell:
+		start := "|"
+		switch {
+		case n.Separator != "":
+			start = n.Separator
+		case n.Header:
+			start = "!"
+		}
+		if n.Attributes != "" {
+			start += n.Attributes + "|"
+		}
+		return pairedCode("cell", start, "", n.Children)
+
+	case *Ref:
+		return unpairedCode("ref", n)
+
+	case *NoWiki:
+		return unpairedCode("nowiki", n)
+
+	case *Tag:
+		return unpairedCode("tag", n)
+
+	case *HtmlTag:
+		return unpairedCode("html", n)
+	}
+
+	return inlineCode{}
+}
+
+// nodeForCode is the reverse of codeForNode. The children may have been
+// translated. The code comes from a file that has been through other tools, so
+// an error is returned if it could not have been created by codeForNode.
+func nodeForCode(code inlineCode, opaqueTags []string) (Node, error) {
+	// Only these codes can start without any wikitext. The others have lost
+	// their data.
+	if code.Start == "" && !(code.Paired && (code.Kind == "bold" || code.Kind == "italic" ||
+		code.Kind == "list" || code.Kind == "row")) {
+		return nil, invalidCode(code)
+	}
+
+	if !code.Paired && code.Kind == "arg" {
+		// The name is not trimmed so that the wikitext is exactly the same.
+		name, value := splitArg(strings.TrimPrefix(code.Start, "|"), opaqueTags)
+		return &Arg{name, []Node{&Text{value}}, true}, nil
+	}
+
+	if !code.Paired && code.Kind == "option" {
+		return &ImageOption{Children: []Node{&Text{strings.TrimPrefix(code.Start, "|")}}, Hidden: true}, nil
+	}
+
+	if !code.Paired && code.Kind == "magic" {
+		// A redirect is only recognised at the start of a page so it can not
+		// be parsed again.
+		return &MagicWord{Value: code.Start}, nil
+	}
+
+	if !code.Paired {
+		switch code.Kind {
+		case "link", "image", "category", "language", "template", "function", "comment", "ref", "nowiki",
+			"tag", "html":
+			return parseNode(code.Start, opaqueTags), nil
+		}
+		return nil, invalidCode(code)
+	}
+
+	start := code.Start
+	switch code.Kind {
+	case "bold":
+		return &Bold{code.Children, code.Unclosed}, nil
+
+	case "italic":
+		return &Italic{code.Children, code.Unclosed}, nil
+
+	case "link":
+		switch {
+		case strings.HasPrefix(start, "[["):
+			if len(start) > 2 {
+				return &Link{start[2 : len(start)-1], false, code.Children}, nil
+			}
+
+		case strings.HasPrefix(start, "["):
+			if len(start) > 1 {
+				return &Link{start[1 : len(start)-1], true, code.Children}, nil
+			}
+		}
+		return nil, invalidCode(code)
+
+	case "image":
+		parts := strings.SplitN(strings.TrimPrefix(start, "[["), ":", 2)
+		if !strings.HasPrefix(start, "[[") || len(parts) != 2 {
+			return nil, invalidCode(code)
+		}
+		image := &Image{Namespace: parts[0], Source: parts[1]}
+		for _, child := range code.Children {
+			if option, ok := child.(*ImageOption); ok {
+				image.Options = append(image.Options, option)
+			}
+		}
+		return image, nil
+
+	case "option":
+		return &ImageOption{Name: strings.TrimSuffix(strings.TrimPrefix(start, "|"), "="), Children: code.Children}, nil
+
+	case "template":
+		template := &Template{Name: strings.TrimPrefix(start, "{{")}
+		for _, child := range code.Children {
+			if arg, ok := child.(*Arg); ok {
+				template.Args = append(template.Args, arg)
+			}
+		}
+		return template, nil
+
+	case "function":
+		parts := strings.SplitN(strings.TrimPrefix(start, "{{"), ":", 2)
+		if !strings.HasPrefix(start, "{{") || len(parts) != 2 {
+			return nil, invalidCode(code)
+		}
+		function := &ParserFunction{Name: parts[0], Expression: parts[1]}
+		for _, child := range code.Children {
+			if arg, ok := child.(*Arg); ok {
+				function.Args = append(function.Args, arg)
+			}
+		}
+		return function, nil
+
+	case "arg":
+		return &Arg{Name: strings.TrimSuffix(strings.TrimPrefix(start, "|"), "="), Children: code.Children}, nil
+
+	case "magic":
+		return &MagicWord{start, code.Children, true}, nil
+
+	case "heading":
+		return &Heading{len(start), code.Children}, nil
+
+	case "list":
+		list := &List{}
+		for _, child := range code.Children {
+			if item, ok := child.(*ListItem); ok {
+				// The prefix of a definition is the prefix of its term.
+				if item.Inline && len(list.Items) > 0 {
+					term := list.Items[len(list.Items)-1].Prefix
+					item.Prefix = term[:len(term)-1] + ":"
+				}
+				list.Items = append(list.Items, item)
+			}
+		}
+		return list, nil
+
+	case "item":
+		return &ListItem{Prefix: start, Children: code.Children}, nil
+
+	case "definition":
+		return &ListItem{Prefix: start, Inline: true, Children: code.Children}, nil
+
+	case "table":
+		table := &Table{Attributes: strings.TrimPrefix(start, "{|")}
+		for _, child := range code.Children {
+			switch child := child.(type) {
+			case *TableCaption:
+				table.Caption = child
+
+			case *TableRow:
+				table.Rows = append(table.Rows, child)
+			}
+		}
+		return table, nil
+
+	case "caption":
+		caption := &TableCaption{Children: code.Children}
+		if len(start) > 2 {
+			caption.Attributes = start[2 : len(start)-1]
+		}
+		return caption, nil
+
+	case "row":
+		row := &TableRow{Attributes: strings.TrimPrefix(start, "|-"), Implicit: start == ""}
+		for _, child := range code.Children {
+			if cell, ok := child.(*TableCell); ok {
+				// A cell on the same line is the same kind as the cell
+				// before it.
+				if cell.Separator != "" && len(row.Cells) > 0 {
+					cell.Header = row.Cells[len(row.Cells)-1].Header
+				}
+				row.Cells = append(row.Cells, cell)
+			}
+		}
+		return row, nil
+
+	case "cell":
+		cell := &TableCell{Header: strings.HasPrefix(start, "!"), Children: code.Children}
+		if strings.HasPrefix(start, "||") || strings.HasPrefix(start, "!!") {
+			cell.Separator = start[:2]
+		}
+		prefix := len(cell.Separator)
+		if prefix == 0 {
+			prefix = 1
+		}
+		if len(start) > prefix {
+			cell.Attributes = start[prefix : len(start)-1]
+		}
+		return cell, nil
+	}
+
+	return nil, invalidCode(code)
+}
+
+// invalidCode is the error for a code that could not have been created by
+// codeForNode.
+func invalidCode(code inlineCode) error {
+	return fmt.Errorf("invalid %v code %q", code.Kind, code.Start)
+}
@@ -0,0 +1,52 @@
+package wikitext
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// nestedTemplates returns templates nested to the depth, each of them inside
+// the first argument of the one before it.
+func nestedTemplates(depth int) string {
+	wiki := "foo"
+	for i := depth; i > 0; i-- {
+		wiki = fmt.Sprintf("{{t%d|a=''%s'' [[l%d|b]]|c}}", i, wiki, i)
+	}
+
+	return wiki
+}
+
+func TestDeepNesting(t *testing.T) {
+	for _, depth := range []int{1, 8, 9, 25, 100} {
+		wiki := "Before " + nestedTemplates(depth) + " after"
+		html := WikiToHtml(wiki)
+
+		if count := strings.Count(html, "<template "); count != depth {
+			t.Errorf("depth %d: expected %d templates, got %d in %v", depth, depth, count, html)
+		}
+		if strings.Contains(html, "{{") || strings.Contains(html, "}}") {
+			t.Errorf("depth %d: expected no wikitext in %v", depth, html)
+		}
+
+		if result := roundTrip(t, wiki); result != wiki {
+			t.Errorf("depth %d: expected '%v', got '%v'", depth, wiki, result)
+		}
+
+		if result, err := XliffToWiki(WikiToXliff(wiki)); err != nil || result != wiki {
+			t.Errorf("depth %d: expected '%v' from XLIFF, got '%v' (%v)", depth, wiki, result, err)
+		}
+	}
+}
+
+func TestDeepNestingLinksAndTables(t *testing.T) {
+	wiki := "[[a|{{b|[[c|{{d|'''e'''}}]]}}]]"
+	for i := 0; i < 20; i++ {
+		wiki = "{|\n|-\n|" + wiki + "\n|}"
+		wiki = "{{x|" + wiki + "}}"
+	}
+
+	if result := roundTrip(t, wiki); result != wiki {
+		t.Errorf("Expected '%v', got '%v'", wiki, result)
+	}
+}
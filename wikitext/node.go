@@ -0,0 +1,311 @@
+package wikitext
+
+// Node is a single element of a parsed wikitext document. The concrete types
+// below are the only implementations; consumers are expected to use a type
+// switch to walk the tree.
+type Node interface {
+	node()
+}
+
+// Text is a run of plain text. It is the only node that holds content that
+// must be shown to translators as-is.
+type Text struct {
+	Value string
+}
+
+// Bold is text wrapped in three apostrophes. Unclosed is true if the end of
+// the line closed it rather than apostrophes.
+type Bold struct {
+	Children []Node
+	Unclosed bool
+}
+
+// Italic is text wrapped in two apostrophes. Unclosed is true if the end of
+// the line closed it rather than apostrophes.
+type Italic struct {
+	Children []Node
+	Unclosed bool
+}
+
+// Link is an internal ([[Target|label]]) or external ([url label]) link. When
+// an internal link has no label the Children will contain the target as Text.
+type Link struct {
+	Target   string
+	External bool
+	Children []Node
+}
+
+// Image is a [[File:...]] link. Namespace is as it was written, such as File,
+// Image or Datei.
+type Image struct {
+	Namespace string
+	Source    string
+	Options   []*ImageOption
+}
+
+// ImageOption is one of the options of an Image. Only the alt text and the
+// caption are shown to translators. Name is the text before the = of the alt
+// text (as it was written) and is empty for the caption. Any other option is
+// Hidden and, like Arg, its Children will be a single Text with the original
+// wikitext.
+type ImageOption struct {
+	Name     string
+	Children []Node
+	Hidden   bool
+}
+
+// Category is a [[Category:Name|sort key]] link. It does not appear where it is
+// written so it is never shown to translators. Namespace is "Category" as it
+// was written and SortKey includes the leading | so that an empty sort key is
+// not lost.
+type Category struct {
+	Namespace string
+	Name      string
+	SortKey   string
+}
+
+// LanguageLink is an interlanguage link like [[fr:Title]] to the same article
+// on another Wikipedia. It is not shown to translators.
+type LanguageLink struct {
+	Language string
+	Title    string
+}
+
+// Template is a {{name|arg|...}} transclusion.
+type Template struct {
+	Name string
+	Args []*Arg
+}
+
+// ParserFunction is a {{#name:expression|arg|...}} parser function such as #if
+// or #switch. The Expression is not shown to translators. Name and Expression
+// keep any whitespace so that the wikitext is not changed.
+//
+// Only the arguments that are output by the function can be translated. The
+// others (like the values to compare in #ifeq or the cases of #switch) are
+// Hidden.
+type ParserFunction struct {
+	Name       string
+	Expression string
+	Args       []*Arg
+}
+
+// Arg is a single argument of a Template. Positional arguments have an empty
+// Name. A Hidden argument is not shown to translators, its Children will be a
+// single Text with the original wikitext.
+type Arg struct {
+	Name     string
+	Children []Node
+	Hidden   bool
+}
+
+// MagicWord is a page-level directive such as __NOTOC__, {{DEFAULTSORT:...}},
+// {{DISPLAYTITLE:...}}, #REDIRECT [[...]] or a signature (~~~~). Value is the
+// original wikitext and it is not shown to translators.
+//
+// When the title of a {{DISPLAYTITLE:...}} is Translatable, Value is only the
+// start (such as "{{DISPLAYTITLE:") and the title is in Children.
+type MagicWord struct {
+	Value        string
+	Children     []Node
+	Translatable bool
+}
+
+// Comment is an HTML comment (<!-- ... -->). The Body is kept verbatim. It is
+// only shown to translators, as a note that can not be changed, if Visible.
+type Comment struct {
+	Body    string
+	Visible bool
+}
+
+// Heading is a line wrapped in 1 to 6 equals signs.
+type Heading struct {
+	Level    int
+	Children []Node
+}
+
+// List is a block of lines that start with *, #, ; or :. Lines that start with
+// a * and # are different lists, but ; and : are in the same list.
+type List struct {
+	Items []*ListItem
+}
+
+// ListItem is a line of a List. Prefix is the characters at the start of the
+// line. Its length is the depth of the item and each character is the type of
+// list at that depth: * (unordered), # (ordered), ; (term) or : (definition or
+// indent). For example, #* is an item of an unordered list inside of an
+// ordered list.
+//
+// A definition on the same line as its term (;term:definition) is a separate
+// item that is Inline.
+type ListItem struct {
+	Prefix   string
+	Inline   bool
+	Children []Node
+}
+
+// Table is a {| ... |} block. The attributes of the table and everything in it
+// are kept as the raw wikitext.
+type Table struct {
+	Attributes string
+	Caption    *TableCaption
+	Rows       []*TableRow
+}
+
+// TableCaption is the |+ line of a Table.
+type TableCaption struct {
+	Attributes string
+	Children   []Node
+}
+
+// TableRow is a row of a Table. The first row of a table does not need to be
+// started with |- so Implicit records when that was the case.
+type TableRow struct {
+	Attributes string
+	Implicit   bool
+	Cells      []*TableCell
+}
+
+// TableCell is a single | (data) or ! (header) cell. The Children may contain
+// a nested Table. Separator is || or !! when the cell is on the same line as
+// the previous cell.
+type TableCell struct {
+	Header     bool
+	Separator  string
+	Attributes string
+	Children   []Node
+}
+
+// Ref is a <ref> footnote. The Body is never parsed because it is not shown to
+// translators. Attributes holds the raw text between the tag name and the end
+// of the opening tag, including any leading whitespace.
+type Ref struct {
+	Attributes  string
+	Body        string
+	SelfClosing bool
+}
+
+// NoWiki is a <nowiki> block. Like Ref, the Body is kept verbatim.
+type NoWiki struct {
+	Attributes  string
+	Body        string
+	SelfClosing bool
+}
+
+// Tag is an extension tag, like <math> or <syntaxhighlight>, that contains code
+// or data that must never be translated. Like NoWiki, the Body is kept
+// verbatim.
+type Tag struct {
+	Name        string
+	Attributes  string
+	Body        string
+	SelfClosing bool
+}
+
+// HtmlTag is a start or end tag of the HTML that is allowed in wikitext, such
+// as <br> or <small>. Each tag is a node of its own because wikitext does not
+// require them to be balanced. Raw is the tag exactly as it was written.
+type HtmlTag struct {
+	Name string
+	Raw  string
+}
+
+func (*Text) node()           {}
+func (*Bold) node()           {}
+func (*Italic) node()         {}
+func (*Link) node()           {}
+func (*Image) node()          {}
+func (*ImageOption) node()    {}
+func (*Category) node()       {}
+func (*LanguageLink) node()   {}
+func (*Template) node()       {}
+func (*ParserFunction) node() {}
+func (*Arg) node()            {}
+func (*MagicWord) node()      {}
+func (*Comment) node()        {}
+func (*Heading) node()        {}
+func (*List) node()           {}
+func (*ListItem) node()       {}
+func (*Table) node()          {}
+func (*TableCaption) node()   {}
+func (*TableRow) node()       {}
+func (*TableCell) node()      {}
+func (*Ref) node()            {}
+func (*NoWiki) node()         {}
+func (*Tag) node()            {}
+func (*HtmlTag) node()        {}
+
+// walkNodes calls visit for each node in the tree, parents before their
+// children. The children of a node are skipped if visit returns false.
+func walkNodes(nodes []Node, visit func(Node) bool) {
+	for _, node := range nodes {
+		if !visit(node) {
+			continue
+		}
+
+		switch n := node.(type) {
+		case *Bold:
+			walkNodes(n.Children, visit)
+
+		case *Italic:
+			walkNodes(n.Children, visit)
+
+		case *Link:
+			walkNodes(n.Children, visit)
+
+		case *Image:
+			for _, option := range n.Options {
+				walkNodes([]Node{option}, visit)
+			}
+
+		case *ImageOption:
+			walkNodes(n.Children, visit)
+
+		case *Template:
+			for _, arg := range n.Args {
+				walkNodes([]Node{arg}, visit)
+			}
+
+		case *ParserFunction:
+			for _, arg := range n.Args {
+				walkNodes([]Node{arg}, visit)
+			}
+
+		case *Arg:
+			walkNodes(n.Children, visit)
+
+		case *MagicWord:
+			walkNodes(n.Children, visit)
+
+		case *Heading:
+			walkNodes(n.Children, visit)
+
+		case *List:
+			for _, item := range n.Items {
+				walkNodes([]Node{item}, visit)
+			}
+
+		case *ListItem:
+			walkNodes(n.Children, visit)
+
+		case *Table:
+			if n.Caption != nil {
+				walkNodes([]Node{n.Caption}, visit)
+			}
+			for _, row := range n.Rows {
+				walkNodes([]Node{row}, visit)
+			}
+
+		case *TableCaption:
+			walkNodes(n.Children, visit)
+
+		case *TableRow:
+			for _, cell := range n.Cells {
+				walkNodes([]Node{cell}, visit)
+			}
+
+		case *TableCell:
+			walkNodes(n.Children, visit)
+		}
+	}
+}
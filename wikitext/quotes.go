@@ -0,0 +1,217 @@
+package wikitext
+
+import "strings"
+
+// quoteRun is a run of apostrophes that toggles italic (2), bold (3) or both
+// (5). It only exists while a line is being parsed. applyQuotes replaces each
+// run with Bold and Italic nodes.
+type quoteRun struct {
+	length int
+}
+
+func (*quoteRun) node() {}
+
+// quotedNode is a node and the formatting that it has.
+type quotedNode struct {
+	node         Node
+	bold, italic bool
+}
+
+// applyQuotes replaces the quote runs with Bold and Italic nodes using the same
+// rules as MediaWiki. Each line is done separately because bold and italic
+// always end at the end of a line.
+func applyQuotes(nodes []Node) []Node {
+	result := []Node{}
+	line := []Node{}
+
+	for _, node := range nodes {
+		text, ok := node.(*Text)
+		if !ok || !strings.Contains(text.Value, "\n") {
+			line = append(line, node)
+			continue
+		}
+
+		first := strings.Index(text.Value, "\n")
+		last := strings.LastIndex(text.Value, "\n")
+		line = append(line, &Text{text.Value[:first]})
+		for _, node := range quotesOnLine(line) {
+			result = appendNode(result, node)
+		}
+		result = appendNode(result, &Text{text.Value[first : last+1]})
+		line = []Node{&Text{text.Value[last+1:]}}
+	}
+
+	for _, node := range quotesOnLine(line) {
+		result = appendNode(result, node)
+	}
+
+	return result
+}
+
+// quotesOnLine applies the quote runs on a single line. It is the same as
+// doQuotes in MediaWiki.
+func quotesOnLine(line []Node) []Node {
+	bolds, italics := 0, 0
+	for _, node := range line {
+		if run, ok := node.(*quoteRun); ok {
+			if run.length != 2 {
+				bolds++
+			}
+			if run.length != 3 {
+				italics++
+			}
+		}
+	}
+
+	// When there are an odd number of both, one of the bold runs is really an
+	// apostrophe followed by italic.
+	if bolds%2 == 1 && italics%2 == 1 {
+		if i := apostropheBold(line); i >= 0 {
+			line = append(line[:i:i], append([]Node{&Text{"'"}, &quoteRun{2}}, line[i+1:]...)...)
+		}
+	}
+
+	items := []quotedNode{}
+	bold, italic, empty := false, false, false
+	for _, node := range line {
+		if text, ok := node.(*Text); ok && text.Value == "" {
+			continue
+		}
+
+		run, ok := node.(*quoteRun)
+		if !ok {
+			items = append(items, quotedNode{node, bold, italic})
+			empty = false
+			continue
+		}
+
+		if run.length != 2 {
+			bold = !bold
+		}
+		if run.length != 3 {
+			italic = !italic
+		}
+		empty = true
+	}
+
+	// Bold or italic at the end of the line is kept even though it is empty
+	// so that the apostrophes are not lost.
+	if empty && (bold || italic) {
+		items = append(items, quotedNode{nil, bold, italic})
+	}
+
+	nodes := groupQuotes(items)
+	markUnclosed(nodes, bold, italic)
+
+	return nodes
+}
+
+// markUnclosed marks the Bold and Italic at the end of the line that were still
+// open when the line ended.
+func markUnclosed(nodes []Node, bold, italic bool) {
+	if len(nodes) == 0 {
+		return
+	}
+
+	switch n := nodes[len(nodes)-1].(type) {
+	case *Bold:
+		if bold {
+			n.Unclosed = true
+			markUnclosed(n.Children, false, italic)
+		}
+
+	case *Italic:
+		if italic {
+			n.Unclosed = true
+			markUnclosed(n.Children, bold, false)
+		}
+	}
+}
+
+// apostropheBold chooses the bold run that should be an apostrophe followed by
+// italic. In order of preference it is the first one after a single letter
+// word (such as the l in l'homme), after a longer word or after a space.
+func apostropheBold(line []Node) int {
+	multiLetterWord, space := -1, -1
+	before := ""
+
+	for i, node := range line {
+		run, ok := node.(*quoteRun)
+		if !ok {
+			before += RenderWiki([]Node{node})
+			continue
+		}
+
+		if run.length == 3 {
+			x1, x2 := byte(0), byte(0)
+			if len(before) > 0 {
+				x1 = before[len(before)-1]
+				x2 = before[0]
+			}
+			if len(before) > 1 {
+				x2 = before[len(before)-2]
+			}
+
+			switch {
+			case x1 == ' ':
+				if space < 0 {
+					space = i
+				}
+			case x2 == ' ':
+				return i
+			case multiLetterWord < 0:
+				multiLetterWord = i
+			}
+		}
+
+		before = ""
+	}
+
+	if multiLetterWord >= 0 {
+		return multiLetterWord
+	}
+
+	return space
+}
+
+// groupQuotes nests the nodes that are bold or italic. The formatting that
+// lasts longer is on the outside.
+func groupQuotes(items []quotedNode) []Node {
+	nodes := []Node{}
+
+	for i := 0; i < len(items); {
+		item := items[i]
+		if !item.bold && !item.italic {
+			if item.node != nil {
+				nodes = appendNode(nodes, item.node)
+			}
+			i++
+			continue
+		}
+
+		bolds, italics := 0, 0
+		for bolds < len(items)-i && items[i+bolds].bold {
+			bolds++
+		}
+		for italics < len(items)-i && items[i+italics].italic {
+			italics++
+		}
+
+		inner := []quotedNode{}
+		if bolds >= italics {
+			for _, item := range items[i : i+bolds] {
+				inner = append(inner, quotedNode{item.node, false, item.italic})
+			}
+			nodes = append(nodes, &Bold{Children: groupQuotes(inner)})
+			i += bolds
+		} else {
+			for _, item := range items[i : i+italics] {
+				inner = append(inner, quotedNode{item.node, item.bold, false})
+			}
+			nodes = append(nodes, &Italic{Children: groupQuotes(inner)})
+			i += italics
+		}
+	}
+
+	return nodes
+}
@@ -0,0 +1,290 @@
+package wikitext
+
+import (
+	"strings"
+)
+
+// segmentFormat is a file format (such as XLIFF) where the translatable text
+// is split into segments and the markup is kept as inline codes. Each code has
+// an id that is unique within the document.
+//
+// The content for a segment is written first and then endRun is called.
+// Content that does not contain any text to translate is still written but
+// endRun will be called with translatable set to false.
+type segmentFormat interface {
+	writeText(s string)
+	writePlaceholder(id int, code inlineCode)
+	writeStartCode(id int, code inlineCode)
+	writeEndCode(id int, code inlineCode)
+
+	// Spans are codes that start and end in different segments, like a table
+	// that has a segment for each cell.
+	writeStartSpan(id int, code inlineCode)
+	writeEndSpan(id int, code inlineCode)
+
+	endRun(translatable bool)
+
+	// endUnit groups all of the segments since the last unit. It is called
+	// before the endRun of the first segment of the next unit.
+	endUnit()
+}
+
+// segmenter splits a document into segments. Each line of the wikitext
+// becomes a unit, including tables and lists which are a single unit with a
+// segment for each cell or item.
+type segmenter struct {
+	format   segmentFormat
+	nextCode int
+
+	runIsEmpty   bool
+	translatable bool
+
+	// A new unit is started at the next segment after the end of a line. A
+	// unit must contain at least one segment so anything that is not
+	// translatable stays with the previous unit.
+	endOfLine  bool
+	hasSegment bool
+}
+
+// segmentNodes writes a parsed document to the format.
+func segmentNodes(nodes []Node, format segmentFormat) {
+	s := &segmenter{format: format, runIsEmpty: true}
+
+	for _, node := range nodes {
+		s.writeBlock(node)
+	}
+
+	s.endRun()
+	format.endUnit()
+}
+
+func (s *segmenter) writeBlock(node Node) {
+	switch n := node.(type) {
+	case *Text:
+		lines := strings.Split(n.Value, "\n")
+		for i, line := range lines {
+			if i > 0 {
+				s.endRun()
+				s.writeText("\n")
+				s.endRun()
+				s.endOfLine = true
+			}
+			s.writeText(line)
+		}
+
+	case *Table:
+		s.endRun()
+		if s.hasSegment {
+			s.format.endUnit()
+			s.hasSegment = false
+		}
+		s.writeTable(n)
+
+	case *List:
+		s.endRun()
+		if s.hasSegment {
+			s.format.endUnit()
+			s.hasSegment = false
+		}
+		s.writeList(n)
+
+	default:
+		s.writeInline(node)
+	}
+}
+
+// writeTable puts the caption and each cell in its own segment. The table and
+// rows are spans around the segments.
+func (s *segmenter) writeTable(table *Table) {
+	tableCode := codeForNode(table)
+	tableID := s.startSpan(tableCode)
+
+	if table.Caption != nil {
+		s.endRun()
+		s.writeInline(table.Caption)
+		s.endRun()
+	}
+
+	for _, row := range table.Rows {
+		rowCode := codeForNode(row)
+		rowID := s.startSpan(rowCode)
+
+		for _, cell := range row.Cells {
+			s.endRun()
+			s.writeInline(cell)
+			s.endRun()
+		}
+
+		s.format.writeEndSpan(rowID, rowCode)
+	}
+
+	s.format.writeEndSpan(tableID, tableCode)
+	s.endRun()
+}
+
+// writeList puts each item in its own segment. The list is a span around the
+// segments.
+func (s *segmenter) writeList(list *List) {
+	listCode := codeForNode(list)
+	listID := s.startSpan(listCode)
+
+	for _, item := range list.Items {
+		s.endRun()
+		s.writeInline(item)
+		s.endRun()
+	}
+
+	s.format.writeEndSpan(listID, listCode)
+	s.endRun()
+}
+
+func (s *segmenter) startSpan(code inlineCode) int {
+	id := s.nextCodeID()
+	s.runIsEmpty = false
+	s.format.writeStartSpan(id, code)
+
+	return id
+}
+
+func (s *segmenter) writeText(text string) {
+	if text == "" {
+		return
+	}
+
+	if strings.TrimSpace(text) != "" {
+		s.translatable = true
+	}
+
+	s.runIsEmpty = false
+	s.format.writeText(text)
+}
+
+func (s *segmenter) writeInline(node Node) {
+	if text, ok := node.(*Text); ok {
+		s.writeText(text.Value)
+		return
+	}
+
+	s.runIsEmpty = false
+	code := codeForNode(node)
+	id := s.nextCodeID()
+
+	if !code.Paired {
+		s.format.writePlaceholder(id, code)
+		return
+	}
+
+	s.format.writeStartCode(id, code)
+	for _, child := range code.Children {
+		s.writeInline(child)
+	}
+	s.format.writeEndCode(id, code)
+}
+
+func (s *segmenter) nextCodeID() int {
+	s.nextCode++
+	return s.nextCode
+}
+
+func (s *segmenter) endRun() {
+	if s.runIsEmpty {
+		return
+	}
+
+	if s.translatable {
+		if s.endOfLine && s.hasSegment {
+			s.format.endUnit()
+		}
+
+		s.endOfLine = false
+		s.hasSegment = true
+	}
+
+	s.format.endRun(s.translatable)
+	s.runIsEmpty = true
+	s.translatable = false
+}
+
+// builderFrame is a code that has been opened but not yet closed.
+type builderFrame struct {
+	id       string
+	code     inlineCode
+	children []Node
+}
+
+// treeBuilder rebuilds a document tree from the segments and codes that were
+// created by segmentNodes. err is the first code that could not be turned back
+// into a node.
+type treeBuilder struct {
+	stack      []*builderFrame
+	opaqueTags []string
+	err        error
+}
+
+func newTreeBuilder(opaqueTags []string) *treeBuilder {
+	return &treeBuilder{stack: []*builderFrame{{}}, opaqueTags: opaqueTags}
+}
+
+func (b *treeBuilder) nodes() ([]Node, error) {
+	for len(b.stack) > 1 {
+		b.pop()
+	}
+
+	return b.stack[0].children, b.err
+}
+
+func (b *treeBuilder) top() *builderFrame {
+	return b.stack[len(b.stack)-1]
+}
+
+// addCode adds the node for a code.
+func (b *treeBuilder) addCode(code inlineCode) {
+	node, err := nodeForCode(code, b.opaqueTags)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return
+	}
+
+	b.add(node)
+}
+
+func (b *treeBuilder) add(node Node) {
+	top := b.top()
+	top.children = appendNode(top.children, node)
+}
+
+func (b *treeBuilder) text(s string) {
+	b.add(&Text{s})
+}
+
+func (b *treeBuilder) placeholder(code inlineCode) {
+	b.addCode(code)
+}
+
+func (b *treeBuilder) open(id string, code inlineCode) {
+	code.Paired = true
+	b.stack = append(b.stack, &builderFrame{id: id, code: code})
+}
+
+// close closes the code with the id, and any codes inside of it that were not
+// closed.
+func (b *treeBuilder) close(id string) {
+	for len(b.stack) > 1 && b.top().id != id {
+		b.pop()
+	}
+
+	b.pop()
+}
+
+func (b *treeBuilder) pop() {
+	if len(b.stack) < 2 {
+		return
+	}
+
+	frame := b.top()
+	b.stack = b.stack[:len(b.stack)-1]
+	frame.code.Children = frame.children
+	b.addCode(frame.code)
+}
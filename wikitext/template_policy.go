@@ -0,0 +1,168 @@
+package wikitext
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TemplatePolicy decides which template arguments are shown to translators.
+// Any argument that is not translatable is hidden in the same way as the body
+// of a <ref>.
+//
+// Templates that do not have a rule are always translatable.
+type TemplatePolicy struct {
+	rules []templateRule
+}
+
+// templateRule is a single line of a rules file. A name that ends with * will
+// match any template that starts with the rest of the name.
+type templateRule struct {
+	name         string
+	translatable []string
+}
+
+// defaultTemplateRules are for common templates on the English Wikipedia.
+const defaultTemplateRules = `
+# Citations
+cite*: title, trans-title, chapter, trans-chapter, quote
+citation: title, trans-title, chapter, trans-chapter, quote
+
+# Infoboxes have many fields that are numbers, images or other settings.
+infobox*: name, caption, alt, altname, nickname, note, notes
+
+# Nothing in these templates should be translated.
+convert:
+coord:
+reflist:
+`
+
+// DefaultTemplatePolicy returns the rules for common templates such as
+// {{cite web}}, {{Infobox}} and {{Convert}}.
+func DefaultTemplatePolicy() *TemplatePolicy {
+	policy := &TemplatePolicy{}
+	if err := policy.Load(strings.NewReader(defaultTemplateRules)); err != nil {
+		panic(err)
+	}
+
+	return policy
+}
+
+// Load adds the rules from a rules file. A rule replaces any earlier rule for
+// the same templates.
+//
+// Each line of the file is the name of a template followed by a colon and the
+// translatable parameters separated by commas. Positional parameters are
+// numbered from 1. Blank lines and lines starting with # are ignored:
+//
+//	# Only the title and quote of a citation will be translated.
+//	cite web: title, quote
+//
+//	# Nothing in any infobox will be translated.
+//	Infobox*:
+func (p *TemplatePolicy) Load(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	input := string(data)
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	offset := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineOffset := offset
+		offset += len(scanner.Text()) + 1
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) < 2 || normalizeTemplateName(parts[0]) == "" {
+			return newError(ErrInvalidTemplatePolicy, input, lineOffset, line, nil)
+		}
+
+		rule := templateRule{name: normalizeTemplateName(parts[0])}
+		for _, param := range strings.Split(parts[1], ",") {
+			if param = strings.TrimSpace(param); param != "" {
+				rule.translatable = append(rule.translatable, param)
+			}
+		}
+
+		p.rules = append(p.rules, rule)
+	}
+
+	return scanner.Err()
+}
+
+// IsTranslatable returns true if the parameter of the template should be
+// shown to translators. The parameter is the name or (for positional
+// parameters) the position, starting at 1.
+func (p *TemplatePolicy) IsTranslatable(template, param string) bool {
+	if p == nil {
+		return true
+	}
+
+	template = normalizeTemplateName(template)
+
+	// Later rules take precedence.
+	for i := len(p.rules) - 1; i >= 0; i-- {
+		rule := p.rules[i]
+		if rule.name == template ||
+			(strings.HasSuffix(rule.name, "*") && strings.HasPrefix(template, rule.name[:len(rule.name)-1])) {
+			return contains(rule.translatable, param)
+		}
+	}
+
+	return true
+}
+
+// normalizeTemplateName makes names that refer to the same template equal.
+// MediaWiki ignores the case of the first letter and the Template: namespace,
+// and treats underscores as spaces.
+func normalizeTemplateName(name string) string {
+	name = strings.Join(strings.Fields(strings.Replace(name, "_", " ", -1)), " ")
+
+	if colon := strings.IndexByte(name, ':'); colon >= 0 && strings.EqualFold(strings.TrimSpace(name[:colon]), "Template") {
+		name = strings.TrimSpace(name[colon+1:])
+	}
+
+	if name == "" {
+		return ""
+	}
+
+	first, size := utf8.DecodeRuneInString(name)
+
+	return string(unicode.ToUpper(first)) + name[size:]
+}
+
+// hideTemplateArgs marks the arguments that are not translatable as Hidden.
+func hideTemplateArgs(nodes []Node, policy *TemplatePolicy) {
+	walkNodes(nodes, func(node Node) bool {
+		template, ok := node.(*Template)
+		if !ok {
+			return true
+		}
+
+		position := 0
+		for _, arg := range template.Args {
+			param := strings.TrimSpace(arg.Name)
+			if param == "" {
+				position++
+				param = strconv.Itoa(position)
+			}
+
+			if !arg.Hidden && !policy.IsTranslatable(template.Name, param) {
+				arg.Hidden = true
+				arg.Children = []Node{&Text{RenderWiki(arg.Children)}}
+			}
+		}
+
+		return true
+	})
+}
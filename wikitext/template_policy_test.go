@@ -0,0 +1,121 @@
+package wikitext
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTemplatePolicyIsTranslatable(t *testing.T) {
+	custom := DefaultTemplatePolicy()
+	err := custom.Load(strings.NewReader("# Comment\n\ncite web: url\nlang: 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy       *TemplatePolicy
+		template     string
+		param        string
+		translatable bool
+	}{
+		{nil, "cite web", "url", true},
+		{DefaultTemplatePolicy(), "cite web", "title", true},
+		{DefaultTemplatePolicy(), "cite web", "url", false},
+		{DefaultTemplatePolicy(), "Cite_web", "title", true},
+		{DefaultTemplatePolicy(), "cite  news", "access-date", false},
+		{DefaultTemplatePolicy(), "Infobox dog breed", "name", true},
+		{DefaultTemplatePolicy(), "Infobox dog breed", "weight", false},
+		{DefaultTemplatePolicy(), "Convert", "1", false},
+		{DefaultTemplatePolicy(), "Other", "1", true},
+		{DefaultTemplatePolicy(), "Template:Cite web", "url", false},
+		{DefaultTemplatePolicy(), "template : cite_web", "url", false},
+		{DefaultTemplatePolicy(), "cite_web", "url", false},
+		{DefaultTemplatePolicy(), "CITE WEB", "url", true},
+		{DefaultTemplatePolicy(), "état", "1", true},
+		{custom, "cite web", "url", true},
+		{custom, "cite web", "title", false},
+		{custom, "cite news", "title", true},
+		{custom, "lang", "1", false},
+		{custom, "lang", "2", true},
+		{custom, "Lang", "1", false},
+		{custom, "Template:Lang", "2", true},
+		{custom, "LANG", "1", true},
+	}
+
+	for _, test := range tests {
+		translatable := test.policy.IsTranslatable(test.template, test.param)
+		if translatable != test.translatable {
+			t.Errorf("%v %v: expected %v, got %v", test.template, test.param, test.translatable, translatable)
+		}
+	}
+}
+
+func TestTemplatePolicyLoadError(t *testing.T) {
+	err := (&TemplatePolicy{}).Load(strings.NewReader("foo: bar\n\ncite web\n"))
+	if !errors.Is(err, ErrInvalidTemplatePolicy) || err.(*Error).Line != 3 {
+		t.Errorf("Expected an invalid template rule on line 3, got %v", err)
+	}
+}
+
+func TestConverterTemplatePolicy(t *testing.T) {
+	converter := &Converter{}
+	options := Options{Templates: DefaultTemplatePolicy()}
+	wiki := "Foo{{cite web|url=http://example.com|title=''Bar''}} {{convert|12|kg}} {{lang|fr|Baz}}"
+
+	html := new(bytes.Buffer)
+	if err := converter.ToHTML(strings.NewReader(wiki), html, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Foo<template name="cite web"><arg name="url" data="aHR0cDovL2V4YW1wbGUuY29t"></arg><arg name="title"><em>Bar</em></arg></template> ` +
+		`<template name="convert"><arg name="" data="MTI="></arg><arg name="" data="a2c="></arg></template> ` +
+		`<template name="lang"><arg name="">fr</arg><arg name="">Baz</arg></template>`
+	if html.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, html)
+	}
+
+	result := new(bytes.Buffer)
+	if err := converter.ToWiki(html, result, options); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != wiki {
+		t.Errorf("Expected '%v', got '%v'", wiki, result)
+	}
+}
+
+func TestConverterTemplatePolicyHidesEverything(t *testing.T) {
+	converter := &Converter{}
+	policy := &TemplatePolicy{}
+	policy.Load(strings.NewReader("*:"))
+	options := Options{Templates: policy}
+
+	for _, test := range examples {
+		expected := roundTrip(t, test.wiki)
+
+		html, wiki := new(bytes.Buffer), new(bytes.Buffer)
+		converter.ToHTML(strings.NewReader(test.wiki), html, options)
+		if err := converter.ToWiki(html, wiki, options); err != nil || wiki.String() != expected {
+			t.Errorf("%v: expected '%v' from HTML, got '%v' (%v)", test.name, expected, wiki, err)
+		}
+
+		xliff := new(bytes.Buffer)
+		converter.ToXLIFF(strings.NewReader(test.wiki), xliff, options)
+		if result, err := XliffToWiki(xliff.String()); err != nil || result != expected {
+			t.Errorf("%v: expected '%v' from XLIFF, got '%v' (%v)", test.name, expected, result, err)
+		}
+
+		xliff.Reset()
+		skeleton := new(bytes.Buffer)
+		converter.ToXLIFF12(strings.NewReader(test.wiki), xliff, skeleton, "Foo", options)
+		if result, err := Xliff12ToWiki(xliff.String(), skeleton.String()); err != nil || result != expected {
+			t.Errorf("%v: expected '%v' from XLIFF 1.2, got '%v' (%v)", test.name, expected, result, err)
+		}
+
+		// Parser functions are not affected by the template rules.
+		if !strings.Contains(test.wiki, "{{#") && strings.Contains(xliff.String(), `ctype="x-wt-arg">|`) {
+			t.Errorf("%v: expected no translatable arguments in %v", test.name, xliff)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package wikitext
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TitleMap maps the titles of pages (or the names of categories) on the source
+// wiki to the same pages on the target wiki. The zero value is an empty map.
+type TitleMap struct {
+	titles map[string]string
+}
+
+// Load adds the titles from a file. Each line is the source title and the
+// target title separated by a tab. Blank lines and lines starting with # are
+// ignored:
+//
+//	# Category names do not include "Category:".
+//	Dog breeds	Races de chiens
+//	Dogs	Chien
+//
+// The file can also be an excerpt of an SQL dump. Each row of the INSERT
+// statements is the source title and the target title, with the language in
+// between if the rows came from the langlinks table. Only the rows for the
+// target language should be included:
+//
+//	INSERT INTO `langlinks` VALUES ('Dog','fr','Chien'),('Cat','fr','Chat');
+func (m *TitleMap) Load(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if m.titles == nil {
+		m.titles = map[string]string{}
+	}
+
+	input := string(data)
+	if strings.Contains(input, "INSERT INTO") {
+		return m.loadSQL(input)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	offset := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineOffset := offset
+		offset += len(line) + 1
+
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, "\t")
+		if len(parts) != 2 || normalizeTitle(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return newError(ErrInvalidTitleMap, input, lineOffset, line, nil)
+		}
+
+		m.titles[normalizeTitle(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return scanner.Err()
+}
+
+// loadSQL adds the rows of each INSERT statement. Every other line, such as
+// CREATE TABLE, is ignored.
+func (m *TitleMap) loadSQL(input string) error {
+	offset := 0
+	for _, line := range strings.SplitAfter(input, "\n") {
+		lineOffset := offset
+		offset += len(line)
+
+		if !strings.HasPrefix(line, "INSERT INTO") {
+			continue
+		}
+
+		rows, ok := sqlRows(line)
+		if !ok {
+			return newError(ErrInvalidTitleMap, input, lineOffset, "INSERT INTO", nil)
+		}
+
+		for _, row := range rows {
+			if (len(row) != 2 && len(row) != 3) || normalizeTitle(row[0]) == "" {
+				return newError(ErrInvalidTitleMap, input, lineOffset, "("+strings.Join(row, ",")+")", nil)
+			}
+
+			m.titles[normalizeTitle(row[0])] = strings.Replace(row[len(row)-1], "_", " ", -1)
+		}
+	}
+
+	return nil
+}
+
+// sqlRows returns the values of each row of an INSERT statement. Strings are
+// unquoted. ok is false if the statement could not be understood.
+func sqlRows(statement string) (rows [][]string, ok bool) {
+	values := strings.Index(statement, " VALUES ")
+	if values < 0 {
+		return nil, false
+	}
+
+	s := statement[values+len(" VALUES "):]
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', ',', '\n', '\r':
+			continue
+
+		case ';':
+			return rows, true
+
+		case '(':
+		default:
+			return nil, false
+		}
+
+		row := []string{}
+		for i++; i < len(s) && s[i] != ')'; i++ {
+			value := new(bytes.Buffer)
+			if s[i] == '\'' {
+				for i++; i < len(s) && s[i] != '\''; i++ {
+					if s[i] == '\\' && i+1 < len(s) {
+						i++
+					}
+					value.WriteByte(s[i])
+				}
+				i++
+			} else {
+				for ; i < len(s) && s[i] != ',' && s[i] != ')'; i++ {
+					value.WriteByte(s[i])
+				}
+			}
+
+			row = append(row, value.String())
+			if i >= len(s) || s[i] == ')' {
+				break
+			}
+		}
+
+		if i >= len(s) {
+			return nil, false
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, true
+}
+
+// Lookup returns the title on the target wiki. ok will be false if the title
+// is not in the map.
+func (m *TitleMap) Lookup(title string) (target string, ok bool) {
+	if m == nil {
+		return "", false
+	}
+
+	target, ok = m.titles[normalizeTitle(title)]
+
+	return
+}
+
+// normalizeTitle makes titles that refer to the same page equal. MediaWiki
+// always capitalizes the first letter and treats underscores as spaces.
+func normalizeTitle(title string) string {
+	title = strings.Join(strings.Fields(strings.Replace(title, "_", " ", -1)), " ")
+	if title == "" {
+		return ""
+	}
+
+	first, size := utf8.DecodeRuneInString(title)
+
+	return string(unicode.ToUpper(first)) + title[size:]
+}
@@ -0,0 +1,142 @@
+package wikitext
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTitleMapLookup(t *testing.T) {
+	titles := &TitleMap{}
+	err := titles.Load(strings.NewReader("# Comment\n\nDog_breeds\tRaces de chiens\nterriers\tTerriers \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		titles *TitleMap
+		title  string
+		target string
+	}{
+		{nil, "Dog breeds", ""},
+		{titles, "Dog breeds", "Races de chiens"},
+		{titles, "dog  breeds", "Races de chiens"},
+		{titles, " Terriers", "Terriers"},
+		{titles, "Dog", ""},
+		{titles, "", ""},
+	}
+
+	for _, test := range tests {
+		target, ok := test.titles.Lookup(test.title)
+		if target != test.target || ok != (test.target != "") {
+			t.Errorf("%v: expected '%v', got '%v' (%v)", test.title, test.target, target, ok)
+		}
+	}
+}
+
+func TestTitleMapLoadError(t *testing.T) {
+	err := (&TitleMap{}).Load(strings.NewReader("Dogs\tChiens\n\nCats Chats\n"))
+	if !errors.Is(err, ErrInvalidTitleMap) || err.(*Error).Line != 3 {
+		t.Errorf("Expected an invalid title mapping on line 3, got %v", err)
+	}
+}
+
+func TestConverterCategories(t *testing.T) {
+	converter := &Converter{}
+	categories := &TitleMap{}
+	categories.Load(strings.NewReader("Dog breeds\tRaces de chiens\n"))
+	report := &ImportReport{}
+	options := Options{Categories: categories, SourceTitle: "Staffordshire Bull Terrier", Report: report}
+	wiki := "Foo\n[[Category:Dog breeds|Staffordshire]]\n[[Category:Terriers]]\n[[Category:Terriers]]\n[[fr:Staffordshire bull terrier]]"
+
+	html := new(bytes.Buffer)
+	if err := converter.ToHTML(strings.NewReader(wiki), html, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Foo\n[[Category:Races de chiens|Staffordshire]]\n[[Category:Terriers]]\n[[Category:Terriers]]\n" +
+		"[[fr:Staffordshire bull terrier]]\n[[en:Staffordshire Bull Terrier]]"
+
+	result := new(bytes.Buffer)
+	if err := converter.ToWiki(html, result, options); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != expected {
+		t.Errorf("Expected '%v', got '%v'", expected, result)
+	}
+	if len(report.UnmappedCategories) != 1 || report.UnmappedCategories[0] != "Terriers" {
+		t.Errorf("Expected Terriers to be unmapped, got %v", report.UnmappedCategories)
+	}
+
+	xliff := new(bytes.Buffer)
+	converter.ToXLIFF(strings.NewReader(wiki), xliff, options)
+	result.Reset()
+	if err := converter.FromXLIFF(xliff, result, options); err != nil || result.String() != expected {
+		t.Errorf("Expected '%v' from XLIFF, got '%v' (%v)", expected, result, err)
+	}
+
+	xliff.Reset()
+	skeleton := new(bytes.Buffer)
+	converter.ToXLIFF12(strings.NewReader(wiki), xliff, skeleton, "Foo", options)
+	result.Reset()
+	if err := converter.FromXLIFF12(xliff, skeleton, result, options); err != nil || result.String() != expected {
+		t.Errorf("Expected '%v' from XLIFF 1.2, got '%v' (%v)", expected, result, err)
+	}
+
+	// The link back to the source is only added once.
+	result.Reset()
+	converter.ToWiki(strings.NewReader(RenderHtml(ParseWiki(expected))), result, options)
+	if result.String() != expected {
+		t.Errorf("Expected '%v', got '%v'", expected, result)
+	}
+}
+
+func TestTitleMapLoadSQL(t *testing.T) {
+	titles := &TitleMap{}
+	err := titles.Load(strings.NewReader("-- MySQL dump\n" +
+		"CREATE TABLE `langlinks` (\n  `ll_title` varbinary(255)\n);\n" +
+		"INSERT INTO `langlinks` VALUES ('Dog','fr','Chien'),('Staffordshire_Bull_Terrier','fr','Staffordshire bull terrier');\n" +
+		"INSERT INTO `titles` VALUES ('King\\'s_Cross','Gare de King\\'s Cross');\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for title, expected := range map[string]string{
+		"Dog":                        "Chien",
+		"Staffordshire Bull Terrier": "Staffordshire bull terrier",
+		"King's Cross":               "Gare de King's Cross",
+	} {
+		if target, _ := titles.Lookup(title); target != expected {
+			t.Errorf("%v: expected '%v', got '%v'", title, expected, target)
+		}
+	}
+
+	err = titles.Load(strings.NewReader("INSERT INTO `langlinks` VALUES (12,'fr','Chien'),(13,'fr'\n"))
+	if !errors.Is(err, ErrInvalidTitleMap) {
+		t.Errorf("Expected an invalid title mapping, got %v", err)
+	}
+}
+
+func TestConverterLinks(t *testing.T) {
+	converter := &Converter{}
+	links := &TitleMap{}
+	links.Load(strings.NewReader("Dog\tChien\nBull terrier\tBull terrier (chien)\n"))
+	report := &ImportReport{}
+	options := Options{Links: links, Report: report}
+	html := `<a href="Dog">Chien</a>, <a href="bull_terrier#History">bull terrier</a>, ` +
+		`<a href="Cat">chat</a>, <a href="Cat">chats</a>, <a href="http://example.com">Dog</a>`
+
+	result := new(bytes.Buffer)
+	if err := converter.ToWiki(strings.NewReader(html), result, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[[Chien]], [[Bull terrier (chien)#History|bull terrier]], [[Cat|chat]], [[Cat|chats]], [http://example.com Dog]"
+	if result.String() != expected {
+		t.Errorf("Expected '%v', got '%v'", expected, result)
+	}
+	if len(report.UnmappedLinks) != 1 || report.UnmappedLinks[0] != "Cat" {
+		t.Errorf("Expected Cat to be unmapped, got %v", report.UnmappedLinks)
+	}
+}
@@ -0,0 +1,271 @@
+package wikitext
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// tmxSegment is the translatable content found at a structural position in a
+// document, such as the third heading or the tenth table cell.
+type tmxSegment struct {
+	Position string
+	Nodes    []Node
+}
+
+// tmxSegmenter finds the segments of a document. Each heading, list item, table
+// caption, table cell and template argument is a segment. Any other line of
+// text is a paragraph.
+type tmxSegmenter struct {
+	counts    map[string]int
+	segments  []tmxSegment
+	paragraph []Node
+}
+
+func segmentsForAlignment(nodes []Node) []tmxSegment {
+	s := &tmxSegmenter{counts: map[string]int{}}
+
+	for _, node := range nodes {
+		s.addBlock(node)
+	}
+	s.endParagraph()
+
+	return s.segments
+}
+
+func (s *tmxSegmenter) add(kind string, nodes []Node) {
+	s.counts[kind]++
+	s.segments = append(s.segments, tmxSegment{
+		Position: fmt.Sprintf("%v %d", kind, s.counts[kind]),
+		Nodes:    nodes,
+	})
+	s.findArgs(nodes)
+}
+
+// findArgs adds the arguments of the templates in nodes as segments of their
+// own.
+func (s *tmxSegmenter) findArgs(nodes []Node) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *Template:
+			s.addArgs(n.Args)
+
+		case *ParserFunction:
+			s.addArgs(n.Args)
+
+		case *Bold:
+			s.findArgs(n.Children)
+
+		case *Italic:
+			s.findArgs(n.Children)
+
+		case *Link:
+			s.findArgs(n.Children)
+
+		case *Image:
+			for _, option := range n.Options {
+				s.findArgs(option.Children)
+			}
+		}
+	}
+}
+
+// addArgs adds the arguments that are not hidden. Hidden arguments are still
+// counted so that the positions match when only one of the documents has
+// hidden arguments.
+func (s *tmxSegmenter) addArgs(args []*Arg) {
+	for _, arg := range args {
+		if arg.Hidden {
+			s.counts["arg"]++
+		} else {
+			s.add("arg", arg.Children)
+		}
+	}
+}
+
+func (s *tmxSegmenter) endParagraph() {
+	if len(s.paragraph) > 0 && strings.TrimSpace(RenderWiki(s.paragraph)) != "" {
+		s.add("paragraph", s.paragraph)
+	}
+
+	s.paragraph = nil
+}
+
+func (s *tmxSegmenter) addBlock(node Node) {
+	switch n := node.(type) {
+	case *Text:
+		lines := strings.Split(n.Value, "\n")
+		for i, line := range lines {
+			if i > 0 {
+				s.endParagraph()
+			}
+			if line != "" {
+				s.paragraph = append(s.paragraph, &Text{line})
+			}
+		}
+
+	case *Heading:
+		s.endParagraph()
+		s.add("heading", n.Children)
+
+	case *List:
+		s.endParagraph()
+		for _, item := range n.Items {
+			s.add("list item", item.Children)
+		}
+
+	case *Table:
+		s.endParagraph()
+		if n.Caption != nil {
+			s.add("table caption", n.Caption.Children)
+		}
+		for _, row := range n.Rows {
+			for _, cell := range row.Cells {
+				s.add("table cell", cell.Children)
+			}
+		}
+
+	default:
+		s.paragraph = append(s.paragraph, node)
+	}
+}
+
+// WikiToTmx creates a TMX 1.4b translation memory from the original wikitext
+// and the HTML file that was translated. The segments of each are aligned by
+// their position in the document so segments are lost if the translator has
+// added or removed headings, list items, etc.
+func WikiToTmx(wikimarkup, translatedHtml, targetLanguage string) (string, error) {
+	return wikiToTmx(wikimarkup, translatedHtml, targetLanguage, Options{})
+}
+
+// wikiToTmx is WikiToTmx with the options that were used to create the HTML.
+func wikiToTmx(wikimarkup, translatedHtml, targetLanguage string, options Options) (string, error) {
+	sourceNodes, err := parseHtml(BalanceHtmlTags(RenderHtml(options.parseWiki(wikimarkup))), options.opaqueTags())
+	if err != nil {
+		return "", err
+	}
+
+	targetNodes, err := parseHtml(translatedHtml, options.opaqueTags())
+	if err != nil {
+		return "", err
+	}
+
+	source := segmentsForAlignment(sourceNodes)
+	target := map[string][]Node{}
+	for _, segment := range segmentsForAlignment(targetNodes) {
+		target[segment.Position] = segment.Nodes
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<tmx version="1.4">` + "\n")
+	fmt.Fprintf(buf, `<header creationtool="wikitranslate" creationtoolversion="%v" segtype="block" o-tmf="wikitranslate" adminlang="en" srclang="%v" datatype="x-wikitext"/>`+"\n",
+		Version, xmlAttributeEscaper.Replace(options.source().Language))
+	buf.WriteString("<body>\n")
+
+	for _, segment := range source {
+		translation, ok := target[segment.Position]
+		if !ok || !hasTranslatableText(segment.Nodes) || !hasTranslatableText(translation) {
+			continue
+		}
+
+		fmt.Fprintf(buf, "<tu>\n<prop type=\"x-position\">%v</prop>\n", segment.Position)
+		writeTmxVariant(buf, options.source().Language, segment.Nodes)
+		writeTmxVariant(buf, targetLanguage, translation)
+		buf.WriteString("</tu>\n")
+	}
+
+	buf.WriteString("</body>\n</tmx>\n")
+
+	return buf.String(), nil
+}
+
+// hasTranslatableText returns true if there is any text that is not inside of
+// a placeholder.
+func hasTranslatableText(nodes []Node) bool {
+	for _, node := range nodes {
+		if text, ok := node.(*Text); ok {
+			if strings.TrimSpace(text.Value) != "" {
+				return true
+			}
+			continue
+		}
+
+		if code := codeForNode(node); code.Paired && isTmxPairedCode(code) &&
+			hasTranslatableText(code.Children) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeTmxVariant(buf *bytes.Buffer, language string, nodes []Node) {
+	fmt.Fprintf(buf, `<tuv xml:lang="%v"><seg>`, xmlAttributeEscaper.Replace(language))
+	w := &tmxSegWriter{buf: buf}
+	w.writeNodes(nodes)
+	buf.WriteString("</seg></tuv>\n")
+}
+
+// isTmxPairedCode returns true for the codes that have translatable content
+// inside a segment. Templates are always placeholders because each of their
+// arguments is a segment of its own.
+func isTmxPairedCode(code inlineCode) bool {
+	switch code.Kind {
+	case "bold", "italic", "link", "image", "option":
+		return true
+	}
+
+	return false
+}
+
+// tmxSegWriter writes the content of a <seg>. The i and x attributes only
+// need to be unique within the segment.
+type tmxSegWriter struct {
+	buf      *bytes.Buffer
+	nextCode int
+}
+
+func (w *tmxSegWriter) writeNodes(nodes []Node) {
+	for _, node := range nodes {
+		w.writeNode(node)
+	}
+}
+
+func (w *tmxSegWriter) writeNode(node Node) {
+	if text, ok := node.(*Text); ok {
+		xmlEscaper.WriteString(w.buf, text.Value)
+		return
+	}
+
+	w.nextCode++
+	id := w.nextCode
+	code := codeForNode(node)
+
+	if !code.Paired || !isTmxPairedCode(code) {
+		fmt.Fprintf(w.buf, `<ph x="%d" type="%v">`, id, tmxCodeType(code.Kind))
+		xmlEscaper.WriteString(w.buf, RenderWiki([]Node{node}))
+		w.buf.WriteString("</ph>")
+		return
+	}
+
+	fmt.Fprintf(w.buf, `<bpt i="%d" x="%d" type="%v">`, id, id, tmxCodeType(code.Kind))
+	xmlEscaper.WriteString(w.buf, code.Start)
+	w.buf.WriteString("</bpt>")
+	w.writeNodes(code.Children)
+	fmt.Fprintf(w.buf, `<ept i="%d">`, id)
+	xmlEscaper.WriteString(w.buf, code.End)
+	w.buf.WriteString("</ept>")
+}
+
+// tmxCodeType returns the type attribute for a kind of code. TMX has its own
+// types for bold, italic and links.
+func tmxCodeType(kind string) string {
+	switch kind {
+	case "bold", "italic", "link":
+		return kind
+	}
+
+	return "x-wt-" + kind
+}
@@ -0,0 +1,55 @@
+package wikitext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWikiToTmx(t *testing.T) {
+	wiki := "== History ==\nThe '''dog''' is a [[Pet|pet]].\n* {{cite|title=Dogs}}\n{|\n|-\n|Black\n|}\n"
+	translated := "<h2>Histoire</h2>\nLe <strong>chien</strong> est un <a href=\"Pet\">animal</a>.\n" +
+		"<li><template name=\"cite\"><arg name=\"title\">Chiens</arg></template></li>\n" +
+		"<table>\n<tr>\n<td>Noir</td>\n</tr>\n</table>\n"
+
+	tmx, err := WikiToTmx(wiki, translated, "fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`<header creationtool="wikitranslate" creationtoolversion="` + Version + `" segtype="block" o-tmf="wikitranslate" adminlang="en" srclang="en" datatype="x-wikitext"/>`,
+		"<tu>\n<prop type=\"x-position\">heading 1</prop>\n" +
+			"<tuv xml:lang=\"en\"><seg> History </seg></tuv>\n" +
+			"<tuv xml:lang=\"fr\"><seg>Histoire</seg></tuv>\n</tu>",
+		"<tu>\n<prop type=\"x-position\">paragraph 1</prop>\n" +
+			`<tuv xml:lang="en"><seg>The <bpt i="1" x="1" type="bold">'''</bpt>dog<ept i="1">'''</ept> is a <bpt i="2" x="2" type="link">[[Pet|</bpt>pet<ept i="2">]]</ept>.</seg></tuv>` + "\n" +
+			`<tuv xml:lang="fr"><seg>Le <bpt i="1" x="1" type="bold">'''</bpt>chien<ept i="1">'''</ept> est un <bpt i="2" x="2" type="link">[[Pet|</bpt>animal<ept i="2">]]</ept>.</seg></tuv>` + "\n</tu>",
+		"<tu>\n<prop type=\"x-position\">arg 1</prop>\n" +
+			"<tuv xml:lang=\"en\"><seg>Dogs</seg></tuv>\n" +
+			"<tuv xml:lang=\"fr\"><seg>Chiens</seg></tuv>\n</tu>",
+		"<tu>\n<prop type=\"x-position\">table cell 1</prop>\n" +
+			"<tuv xml:lang=\"en\"><seg>Black</seg></tuv>\n" +
+			"<tuv xml:lang=\"fr\"><seg>Noir</seg></tuv>\n</tu>",
+	} {
+		if !strings.Contains(tmx, expected) {
+			t.Errorf("Expected:\n%v\nto contain:\n%v", tmx, expected)
+		}
+	}
+
+	// The list item only contains a template so there is nothing to
+	// translate.
+	if strings.Contains(tmx, "list item 1") {
+		t.Errorf("Did not expect a segment for the list item:\n%v", tmx)
+	}
+}
+
+func TestWikiToTmxSkipsMissingSegments(t *testing.T) {
+	tmx, err := WikiToTmx("* one\n* two\n", "<li>un</li>\n", "fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(tmx, "list item 1") || strings.Contains(tmx, "list item 2") {
+		t.Errorf("Expected only the first list item:\n%v", tmx)
+	}
+}
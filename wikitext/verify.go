@@ -0,0 +1,342 @@
+package wikitext
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// RoundTripDifference is a part of the wikitext that is changed by converting
+// it to HTML and back again.
+type RoundTripDifference struct {
+	// Line and Column are where the change starts in the original wikitext.
+	// They both start at 1.
+	Line   int
+	Column int
+
+	// Construct is the innermost wiki construct (template, table, ref...) at
+	// the start of the change.
+	Construct string
+
+	// The lines that changed.
+	Original  string
+	RoundTrip string
+}
+
+func (d RoundTripDifference) String() string {
+	return fmt.Sprintf("line %d, column %d (%v):\n  original:   %q\n  round trip: %q",
+		d.Line, d.Column, d.Construct, d.Original, d.RoundTrip)
+}
+
+// VerifyRoundTrip converts the wikitext to HTML and back again and returns
+// all of the differences. An empty result means the round trip is lossless.
+func VerifyRoundTrip(wikimarkup string) ([]RoundTripDifference, error) {
+	return verifyRoundTrip(wikimarkup, Options{})
+}
+
+// verifyRoundTrip is VerifyRoundTrip with the options used to convert the
+// wikitext.
+func verifyRoundTrip(wikimarkup string, options Options) ([]RoundTripDifference, error) {
+	nodes, err := parseHtml(BalanceHtmlTags(RenderHtml(options.parseWiki(wikimarkup))), options.opaqueTags())
+	if err != nil {
+		return nil, err
+	}
+
+	escapeMarkup(nodes, options.opaqueTags())
+	roundTrip := RenderWiki(nodes)
+
+	if roundTrip == wikimarkup {
+		return nil, nil
+	}
+
+	original := strings.Split(wikimarkup, "\n")
+	changed := strings.Split(roundTrip, "\n")
+	differences := []RoundTripDifference{}
+
+	for _, hunk := range diffLines(original, changed) {
+		a := strings.Join(original[hunk.originalStart:hunk.originalEnd], "\n")
+		b := strings.Join(changed[hunk.changedStart:hunk.changedEnd], "\n")
+
+		// Find the first character that is different.
+		prefix := 0
+		for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+			prefix++
+		}
+		for prefix > 0 && !utf8RuneStartOf(a, prefix) {
+			prefix--
+		}
+
+		line := hunk.originalStart + 1 + strings.Count(a[:prefix], "\n")
+		lineStart := strings.LastIndex(a[:prefix], "\n") + 1
+
+		offset := prefix
+		for _, s := range original[:hunk.originalStart] {
+			offset += len(s) + 1
+		}
+
+		differences = append(differences, RoundTripDifference{
+			Line:      line,
+			Column:    utf8.RuneCountInString(a[lineStart:prefix]) + 1,
+			Construct: constructAt(wikimarkup, offset, options.opaqueTags()),
+			Original:  a,
+			RoundTrip: b,
+		})
+	}
+
+	return differences, nil
+}
+
+// utf8RuneStartOf is true if the byte at i is the start of a character.
+func utf8RuneStartOf(s string, i int) bool {
+	return i >= len(s) || utf8.RuneStart(s[i])
+}
+
+// diffHunk is a range of lines that were replaced by another range of lines.
+type diffHunk struct {
+	originalStart, originalEnd int
+	changedStart, changedEnd   int
+}
+
+// diffLines finds the lines that are different using the longest common
+// subsequence.
+func diffLines(a, b []string) []diffHunk {
+	matches := matchLines(a, b, 0, 0, nil)
+
+	hunks := []diffHunk{}
+	i, j := 0, 0
+	for _, match := range append(matches, lineMatch{len(a), len(b)}) {
+		if match.original > i || match.changed > j {
+			hunks = append(hunks, diffHunk{i, match.original, j, match.changed})
+		}
+		i, j = match.original+1, match.changed+1
+	}
+
+	return hunks
+}
+
+// lineMatch is a line that is in both the original and changed wikitext.
+type lineMatch struct {
+	original, changed int
+}
+
+// matchLines appends the lines of the longest common subsequence of a and b,
+// which start at the lines aStart and bStart. It uses the linear space version
+// of Myers' algorithm, which only needs memory for two rows of diagonals and
+// is fast when there are only a few differences.
+func matchLines(a, b []string, aStart, bStart int, matches []lineMatch) []lineMatch {
+	// The lines at the start and end that have not changed are matched
+	// without searching.
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		matches = append(matches, lineMatch{aStart, bStart})
+		a, b = a[1:], b[1:]
+		aStart++
+		bStart++
+	}
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	if len(a) > 0 && len(b) > 0 {
+		if x, y, ok := middleSnake(a, b); ok {
+			matches = matchLines(a[:x], b[:y], aStart, bStart, matches)
+			matches = matchLines(a[x:], b[y:], aStart+x, bStart+y, matches)
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		matches = append(matches, lineMatch{aStart + len(a) + i, bStart + len(b) + i})
+	}
+
+	return matches
+}
+
+// middleSnake searches forwards from the start and backwards from the end of
+// a and b at the same time until the two paths meet. The point where they
+// meet splits both into two smaller problems. It is false if a and b have no
+// lines in common.
+func middleSnake(a, b []string) (x, y int, ok bool) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	// When the difference in length is odd the paths can only meet while
+	// searching forwards, otherwise they meet while searching backwards.
+	odd := delta%2 != 0
+	kForwardStart, kForwardEnd, kBackwardStart, kBackwardEnd := 0, 0, 0, 0
+
+	for d := 0; d < maxD; d++ {
+		for k := -d + kForwardStart; k <= d-kForwardEnd; k += 2 {
+			var x1 int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x1 = forward[offset+k+1]
+			} else {
+				x1 = forward[offset+k-1] + 1
+			}
+			y1 := x1 - k
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			forward[offset+k] = x1
+
+			switch {
+			case x1 > n:
+				kForwardEnd += 2
+			case y1 > m:
+				kForwardStart += 2
+			case odd:
+				if i := offset + delta - k; i >= 0 && i < len(backward) && backward[i] != -1 && x1 >= n-backward[i] {
+					return x1, y1, true
+				}
+			}
+		}
+
+		for k := -d + kBackwardStart; k <= d-kBackwardEnd; k += 2 {
+			var x2 int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x2 = backward[offset+k+1]
+			} else {
+				x2 = backward[offset+k-1] + 1
+			}
+			y2 := x2 - k
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			backward[offset+k] = x2
+
+			switch {
+			case x2 > n:
+				kBackwardEnd += 2
+			case y2 > m:
+				kBackwardStart += 2
+			case !odd:
+				if i := offset + delta - k; i >= 0 && i < len(forward) && forward[i] != -1 && forward[i] >= n-x2 {
+					return forward[i], offset + forward[i] - i, true
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// constructAt returns the name of the innermost construct at an offset of the
+// wikitext. It only looks at the markup before the offset so it still works
+// when the wikitext cannot be parsed.
+func constructAt(wikimarkup string, offset int, opaqueTags []string) string {
+	stack := []string{}
+	line := ""
+
+	pop := func(names ...string) {
+		if len(stack) > 0 && contains(names, stack[len(stack)-1]) {
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	for i := 0; i <= offset && i < len(wikimarkup); i++ {
+		rest := wikimarkup[i:]
+		opening := i == offset
+
+		if i == 0 || wikimarkup[i-1] == '\n' {
+			line = ""
+			switch {
+			case strings.HasPrefix(rest, "{|"):
+				stack = append(stack, "table")
+			case strings.HasPrefix(rest, "|}") && !opening:
+				pop("table")
+			case rest[0] == '=':
+				line = "heading"
+			case listType(rest[0]) != 0:
+				line = "list"
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(rest, "<nowiki"):
+			end := strings.Index(rest, "</nowiki>")
+			if end < 0 || i+end >= offset {
+				return "nowiki"
+			}
+			i += end
+
+		case tagNameRegexp.MatchString(rest) &&
+			contains(opaqueTags, strings.ToLower(tagNameRegexp.FindStringSubmatch(rest)[1])):
+			name := tagNameRegexp.FindStringSubmatch(rest)[1]
+			end := strings.Index(rest, "</"+name+">")
+			if openEnd := strings.IndexByte(rest, '>'); openEnd > 0 && rest[openEnd-1] == '/' {
+				end = openEnd
+			}
+			if end < 0 || i+end >= offset {
+				return strings.ToLower(name)
+			}
+			i += end
+
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest, "-->")
+			if end < 0 || i+end >= offset {
+				return "comment"
+			}
+			i += end
+
+		case strings.HasPrefix(rest, "<ref"):
+			end := strings.Index(rest, ">")
+			if end < 0 || i+end >= offset || rest[end-1] != '/' {
+				stack = append(stack, "ref")
+			}
+			if end > 0 {
+				i += end
+			}
+
+		case strings.HasPrefix(rest, "</ref>") && !opening:
+			pop("ref")
+
+		case strings.HasPrefix(rest, "{{#"):
+			stack = append(stack, "parser function")
+			i++
+
+		case strings.HasPrefix(rest, "{{"):
+			stack = append(stack, "template")
+			i++
+
+		case strings.HasPrefix(rest, "}}") && !opening:
+			pop("template", "parser function")
+			i++
+
+		case strings.HasPrefix(rest, "[[Category:"):
+			stack = append(stack, "category")
+			i++
+
+		case strings.HasPrefix(rest, "[[") && imageNamespaceRegexp.MatchString(rest[2:]):
+			stack = append(stack, "image")
+			i++
+
+		case strings.HasPrefix(rest, "[["):
+			stack = append(stack, "link")
+			i++
+
+		case strings.HasPrefix(rest, "]]") && !opening:
+			pop("link", "image", "category")
+			i++
+		}
+	}
+
+	if len(stack) > 0 {
+		return stack[len(stack)-1]
+	}
+	if line != "" {
+		return line
+	}
+
+	return "text"
+}
@@ -0,0 +1,131 @@
+package wikitext
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRoundTrip(t *testing.T) {
+	for _, test := range examples {
+		expected := roundTrip(t, test.wiki)
+		differences, err := VerifyRoundTrip(test.wiki)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if (expected == test.wiki) != (len(differences) == 0) {
+			t.Errorf("%v: expected the result to be the same as the round trip", test.name)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		wiki        string
+		differences []RoundTripDifference
+	}{
+		{"v101", "foo [[bar]] {{baz|qux}}\n", []RoundTripDifference{}},
+		{"v102", "a\n{|\n|-\n  | x\n|}\nb", []RoundTripDifference{
+			{4, 1, "table", "  | x", "| x"},
+		}},
+		{"v103", "{|\n\n|-\n| a\n|}", []RoundTripDifference{
+			{2, 1, "table", "", ""},
+		}},
+		{"v104", "== History == <!-- see talk -->\nfoo", []RoundTripDifference{}},
+		{"v105", "==History==<ref>x</ref>\nfoo", []RoundTripDifference{}},
+		{"v106", "=a=<span>x</span>", []RoundTripDifference{}},
+	}
+
+	for _, test := range tests {
+		differences, err := VerifyRoundTrip(test.wiki)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if len(differences) != len(test.differences) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.differences, differences)
+			continue
+		}
+
+		for i, difference := range differences {
+			if difference != test.differences[i] {
+				t.Errorf("%v: expected %v, got %v", test.name, test.differences[i], difference)
+			}
+		}
+	}
+}
+
+func TestConstructAtOpaqueTags(t *testing.T) {
+	wiki := "a <hiero>{{b</hiero> c"
+	if construct := constructAt(wiki, 12, append([]string{"hiero"}, defaultOpaqueTags...)); construct != "hiero" {
+		t.Errorf("Expected hiero, got %v", construct)
+	}
+	if construct := constructAt(wiki, 12, defaultOpaqueTags); construct != "template" {
+		t.Errorf("Expected template, got %v", construct)
+	}
+}
+
+func TestConstructAt(t *testing.T) {
+	wiki := "== a ==\n* b {{c|[[d]] e}} <ref name=\"f\"/> g <ref>h</ref> <nowiki>{{i</nowiki>\n[[File:j.png|k]]\nl <!-- m --> <templatestyles src=\"o.css\" /> <math>n</math> p"
+
+	for _, test := range []struct {
+		text      string
+		construct string
+	}{
+		{"a", "heading"},
+		{"b", "list"},
+		{"{{c", "template"},
+		{"[[d", "link"},
+		{"d", "link"},
+		{"e}}", "template"},
+		{" g ", "list"},
+		{"h<", "ref"},
+		{"{{i", "nowiki"},
+		{"|k", "image"},
+		{"\nl", "text"},
+		{" m ", "comment"},
+		{"n<", "math"},
+		{" p", "text"},
+	} {
+		offset := strings.Index(wiki, test.text)
+		if construct := constructAt(wiki, offset, defaultOpaqueTags); construct != test.construct {
+			t.Errorf("%v: expected %v, got %v", test.text, test.construct, construct)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, test := range []struct {
+		original, changed string
+		hunks             []diffHunk
+	}{
+		{"a b c", "a b c", []diffHunk{}},
+		{"a b c", "a x c", []diffHunk{{1, 2, 1, 2}}},
+		{"a b c", "a c", []diffHunk{{1, 2, 1, 1}}},
+		{"a c", "a b c", []diffHunk{{1, 1, 1, 2}}},
+		{"a b c d e", "x b c y e z", []diffHunk{{0, 1, 0, 1}, {3, 4, 3, 4}, {5, 5, 5, 6}}},
+		{"a b a b a", "b a b", []diffHunk{{0, 1, 0, 0}, {4, 5, 3, 3}}},
+		{"a b c", "", []diffHunk{{0, 3, 0, 0}}},
+	} {
+		hunks := diffLines(strings.Fields(test.original), strings.Fields(test.changed))
+		if !reflect.DeepEqual(hunks, test.hunks) {
+			t.Errorf("%v -> %v: expected %v, got %v", test.original, test.changed, test.hunks, hunks)
+		}
+	}
+}
+
+func TestDiffLinesLongPage(t *testing.T) {
+	original := make([]string, 50000)
+	for i := range original {
+		original[i] = strconv.Itoa(i)
+	}
+	changed := append([]string{}, original...)
+	changed[100] = "x"
+	changed[40000] = "y"
+
+	hunks := diffLines(original, changed)
+	expected := []diffHunk{{100, 101, 100, 101}, {40000, 40001, 40000, 40001}}
+	if !reflect.DeepEqual(hunks, expected) {
+		t.Errorf("Expected %v, got %v", expected, hunks)
+	}
+}
@@ -0,0 +1,998 @@
+package wikitext
+
+import (
+	"regexp"
+	"strings"
+)
+
+var parserFunctionNameRegexp = regexp.MustCompile(`^\s*#[a-zA-Z]+$`)
+
+// parserFunctionOutputs are the parser functions that output their arguments
+// so the arguments can be translated. All of the arguments of any other parser
+// function (like #expr or #time) are hidden.
+var parserFunctionOutputs = []string{"#if", "#ifeq", "#ifexist", "#ifexpr", "#iferror", "#switch"}
+
+var redirectRegexp = regexp.MustCompile(`(?i)^#REDIRECT\s*:?\s*\[\[[^\[\]\n]+\]\]`)
+
+var categoryRegexp = regexp.MustCompile(`^\s*[Cc]ategory\s*:`)
+
+// imageNamespaceRegexp matches the namespace of an image, including the
+// aliases used by the larger wikis.
+var imageNamespaceRegexp = regexp.MustCompile(`^\s*(?i:File|Image|Datei|Bild|Fichier|Archivo|Imagen|Ficheiro|Arquivo|Imagem|` +
+	`Immagine|Plik|Bestand|Afbeelding|Tiedosto|Файл|Изображение|ファイル|画像|文件|图像|파일)\s*:`)
+
+// imageOptionRegexp matches the options of an image that are not the caption.
+var imageOptionRegexp = regexp.MustCompile(`(?s)^\s*(thumb|thumbnail|frame|framed|frameless|border|left|right|` +
+	`center|centre|none|baseline|sub|super|top|text-top|middle|bottom|text-bottom|upright|loop|muted|` +
+	`\d*(x\d+)?\s*px|(link|upright|page|lang|class|thumbtime|start|end|thumb|thumbnail|manualthumb)\s*=.*)\s*$`)
+
+var imageAltRegexp = regexp.MustCompile(`^\s*alt\s*=`)
+
+// languageCodes are the prefixes of interlanguage links, which are the codes of
+// the Wikipedias. Any other prefix, such as wikt: or doi:, is an interwiki or
+// ordinary link.
+var languageCodes = []string{
+	"aa", "ab", "ace", "ady", "af", "als", "alt", "am", "ami", "an", "ang", "anp", "ar", "arc", "ary",
+	"arz", "as", "ast", "atj", "av", "avk", "awa", "ay", "az", "azb", "ba", "ban", "bar", "bat-smg",
+	"bbc", "bcl", "be", "be-tarask", "be-x-old", "bew", "bg", "bh", "bi", "bjn", "blk", "bm", "bn",
+	"bo", "bpy", "br", "bs", "bug", "bxr", "ca", "cbk-zam", "cdo", "ce", "ceb", "ch", "cho", "chr",
+	"chy", "ckb", "co", "cr", "crh", "cs", "csb", "cu", "cv", "cy", "da", "dag", "de", "dga", "din",
+	"diq", "dsb", "dtp", "dty", "dv", "dz", "ee", "el", "eml", "en", "eo", "es", "et", "eu", "ext",
+	"fa", "fat", "ff", "fi", "fiu-vro", "fj", "fo", "fon", "fr", "frp", "frr", "fur", "fy", "ga",
+	"gag", "gan", "gcr", "gd", "gl", "glk", "gn", "gom", "gor", "got", "gpe", "gu", "guc", "gur",
+	"guw", "gv", "ha", "hak", "haw", "he", "hi", "hif", "ho", "hr", "hsb", "ht", "hu", "hy", "hyw",
+	"hz", "ia", "iba", "id", "ie", "ig", "igl", "ii", "ik", "ilo", "inh", "io", "is", "it", "iu",
+	"ja", "jam", "jbo", "jv", "ka", "kaa", "kab", "kbd", "kbp", "kcg", "kg", "kge", "ki", "kj", "kk",
+	"kl", "km", "kn", "knc", "ko", "koi", "kr", "krc", "ks", "ksh", "ku", "kus", "kv", "kw", "ky",
+	"la", "lad", "lb", "lbe", "lez", "lfn", "lg", "li", "lij", "lld", "lmo", "ln", "lo", "lrc", "lt",
+	"ltg", "lv", "lzh", "mad", "mai", "map-bms", "mdf", "mg", "mh", "mhr", "mi", "min", "mk", "ml",
+	"mn", "mni", "mnw", "mos", "mr", "mrj", "ms", "mt", "mus", "mwl", "my", "myv", "mzn", "na", "nah",
+	"nap", "nb", "nds", "nds-nl", "ne", "new", "ng", "nia", "nl", "nn", "no", "nov", "nqo", "nr",
+	"nrm", "nso", "nup", "nv", "ny", "oc", "olo", "om", "or", "os", "pa", "pag", "pam", "pap", "pcd",
+	"pcm", "pdc", "pfl", "pi", "pih", "pl", "pms", "pnb", "pnt", "ps", "pt", "pwn", "qu", "rm", "rmy",
+	"rn", "ro", "roa-rup", "roa-tara", "rsk", "ru", "rue", "rw", "sa", "sah", "sat", "sc", "scn",
+	"sco", "sd", "se", "sg", "sh", "shi", "shn", "si", "simple", "sk", "skr", "sl", "sm", "smn", "sn",
+	"so", "sq", "sr", "srn", "ss", "st", "stq", "su", "sv", "sw", "syl", "szl", "szy", "ta", "tay",
+	"tcy", "tdd", "te", "tet", "tg", "th", "ti", "tig", "tk", "tl", "tly", "tn", "to", "tpi", "tr",
+	"trv", "ts", "tt", "tum", "tw", "ty", "tyv", "udm", "ug", "uk", "ur", "uz", "ve", "vec", "vep",
+	"vi", "vls", "vo", "wa", "war", "wo", "wuu", "xal", "xh", "xmf", "yi", "yo", "yue", "za", "zea",
+	"zgh", "zh", "zh-classical", "zh-min-nan", "zh-yue", "zu",
+}
+
+var tagNameRegexp = regexp.MustCompile(`^<([a-zA-Z]+)[\s/>]`)
+
+// defaultOpaqueTags are the extension tags whose content is code or data that
+// must never be translated. More can be added with Options.OpaqueTags.
+var defaultOpaqueTags = []string{
+	"math", "chem", "ce", "syntaxhighlight", "source", "pre", "score", "timeline",
+	"templatestyles", "graph", "mapframe",
+}
+
+// wikiHtmlTags are the HTML tags that can be used in wikitext. They are kept as
+// tags in the pseudo-HTML rather than being escaped as text.
+var wikiHtmlTags = []string{
+	"abbr", "b", "bdi", "bdo", "big", "blockquote", "br", "caption", "center", "cite", "code", "data", "dd",
+	"del", "dfn", "div", "dl", "dt", "em", "font", "h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "ins",
+	"kbd", "li", "mark", "ol", "p", "q", "rb", "rp", "rt", "rtc", "ruby", "s", "samp", "small", "span",
+	"strike", "strong", "sub", "sup", "table", "td", "th", "time", "tr", "tt", "u", "ul", "var", "wbr",
+}
+
+// pageMagicWords are the magic words that change the page (rather than output
+// text) and are written like a template with a colon, such as
+// {{DEFAULTSORT:...}}.
+var pageMagicWords = []string{"DEFAULTSORT", "DEFAULTSORTKEY", "DEFAULTCATEGORYSORT", "DISPLAYTITLE"}
+
+// behaviourSwitches are the magic words that are written between double
+// underscores, such as __NOTOC__. Like MediaWiki, they can be in any case
+// except for the ones in caseSensitiveBehaviourSwitches. Any other word
+// between double underscores is text.
+var behaviourSwitches = []string{"NOTOC", "FORCETOC", "TOC", "NOEDITSECTION", "NOGALLERY", "NOTITLECONVERT", "NOTC",
+	"NOCONTENTCONVERT", "NOCC", "DISAMBIG"}
+
+var caseSensitiveBehaviourSwitches = []string{"NEWSECTIONLINK", "NONEWSECTIONLINK", "HIDDENCAT", "EXPECTUNUSEDCATEGORY",
+	"EXPECTUNUSEDTEMPLATE", "INDEX", "NOINDEX", "STATICREDIRECT", "NOGLOBAL", "ARCHIVEDTALK", "NOTALK",
+	"EXPECTED_UNCONNECTED_PAGE"}
+
+// wikiParser is a recursive descent parser for wikitext. Constructs that have
+// a closing delimiter ({{ }}, [[ ]], {| |}, <ref>) are measured first and only
+// their inner text is handed to a new parser. That way a nested construct can
+// never consume the delimiters that belong to its parent.
+type wikiParser struct {
+	input string
+	pos   int
+
+	// lineStart is true when the start of the input is also the start of a
+	// line. It is false for fragments such as template arguments.
+	lineStart bool
+
+	// opaqueTags are the names of the tags that are kept as a Tag.
+	opaqueTags []string
+}
+
+// ParseWiki parses a complete wikitext document.
+func ParseWiki(wikimarkup string) []Node {
+	return parseWiki(wikimarkup, defaultOpaqueTags)
+}
+
+func parseWiki(wikimarkup string, opaqueTags []string) []Node {
+	p := &wikiParser{input: wikimarkup, lineStart: true, opaqueTags: opaqueTags}
+	return p.parseNodes(nil)
+}
+
+// parseNode parses wikitext that is expected to be a single node, such as the
+// content of a placeholder. It is returned as Text if it is not.
+func parseNode(wikimarkup string, opaqueTags []string) Node {
+	nodes := parseFragment(wikimarkup, opaqueTags)
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+
+	return &Text{wikimarkup}
+}
+
+// parseFragment parses wikitext that does not start at the beginning of a
+// line, such as a link label or template argument.
+func parseFragment(wikimarkup string, opaqueTags []string) []Node {
+	p := &wikiParser{input: wikimarkup, opaqueTags: opaqueTags}
+	return p.parseNodes(nil)
+}
+
+// parseChild is like parseFragment but has the same settings as the parser.
+func (p *wikiParser) parseChild(wikimarkup string) []Node {
+	return parseFragment(wikimarkup, p.opaqueTags)
+}
+
+// split is splitTopLevel with the opaque tags of the parser.
+func (p *wikiParser) split(s string, sep byte, n int) []string {
+	return splitTopLevel(s, string(sep), n, p.opaqueTags)
+}
+
+// appendNode adds a node to the list, merging adjacent Text nodes.
+func appendNode(nodes []Node, node Node) []Node {
+	if text, ok := node.(*Text); ok {
+		if text.Value == "" {
+			return nodes
+		}
+
+		if len(nodes) > 0 {
+			if last, ok := nodes[len(nodes)-1].(*Text); ok {
+				last.Value += text.Value
+				return nodes
+			}
+		}
+	}
+
+	return append(nodes, node)
+}
+
+func (p *wikiParser) atLineStart() bool {
+	if p.pos == 0 {
+		return p.lineStart
+	}
+
+	return p.input[p.pos-1] == '\n'
+}
+
+func (p *wikiParser) restOfLine() string {
+	end := strings.IndexByte(p.input[p.pos:], '\n')
+	if end < 0 {
+		return p.input[p.pos:]
+	}
+
+	return p.input[p.pos : p.pos+end]
+}
+
+// parseNodes consumes the input until the end is reached or stop returns true.
+func (p *wikiParser) parseNodes(stop func() bool) []Node {
+	nodes := []Node{}
+
+	for p.pos < len(p.input) {
+		if stop != nil && stop() {
+			break
+		}
+
+		if p.atLineStart() {
+			if lineNodes := p.parseLine(); lineNodes != nil {
+				for _, node := range lineNodes {
+					nodes = appendNode(nodes, node)
+				}
+				continue
+			}
+		}
+
+		if node := p.parseInline(); node != nil {
+			nodes = appendNode(nodes, node)
+			continue
+		}
+
+		nodes = appendNode(nodes, &Text{p.input[p.pos : p.pos+1]})
+		p.pos++
+	}
+
+	return applyQuotes(nodes)
+}
+
+// parseLine tries to parse the constructs that are only recognised at the
+// start of a line. It returns nil if there are none.
+func (p *wikiParser) parseLine() []Node {
+	// A redirect must be at the very start of the page.
+	if p.pos == 0 && p.lineStart {
+		if redirect := redirectRegexp.FindString(p.input); redirect != "" {
+			p.pos += len(redirect)
+			return []Node{&MagicWord{Value: redirect}}
+		}
+	}
+
+	if nodes := p.parseHeading(); nodes != nil {
+		return nodes
+	}
+
+	if listType(p.input[p.pos]) != 0 {
+		return []Node{p.parseList()}
+	}
+
+	if table := p.parseTable(); table != nil {
+		return []Node{table}
+	}
+
+	return nil
+}
+
+func (p *wikiParser) parseInline() Node {
+	rest := p.input[p.pos:]
+
+	switch {
+	case strings.HasPrefix(rest, "<nowiki"):
+		if attributes, body, selfClosing, ok := p.parseTag("nowiki"); ok {
+			return &NoWiki{attributes, body, selfClosing}
+		}
+
+	case strings.HasPrefix(rest, "<!--"):
+		if end := strings.Index(rest[4:], "-->"); end >= 0 {
+			p.pos += 4 + end + 3
+			return &Comment{Body: rest[4 : 4+end]}
+		}
+
+	case strings.HasPrefix(rest, "<ref"):
+		if attributes, body, selfClosing, ok := p.parseTag("ref"); ok {
+			return &Ref{attributes, body, selfClosing}
+		}
+
+	case tagNameRegexp.MatchString(rest):
+		name := tagNameRegexp.FindStringSubmatch(rest)[1]
+		if !contains(p.opaqueTags, strings.ToLower(name)) {
+			break
+		}
+		if attributes, body, selfClosing, ok := p.parseTag(name); ok {
+			return &Tag{name, attributes, body, selfClosing}
+		}
+
+	case strings.HasPrefix(rest, "{{"):
+		if strings.HasPrefix(rest, "{{{") {
+			if parameter := p.parseParameter(); parameter != nil {
+				return parameter
+			}
+
+			// Like MediaWiki, the extra brace is text when the parameter
+			// is not closed.
+			p.pos++
+			return &Text{"{"}
+		}
+		return p.parseTemplate()
+
+	case strings.HasPrefix(rest, "~~~"):
+		// A signature. The whole run of tildes is kept together because
+		// MediaWiki replaces the longest signatures first.
+		tildes := countPrefix(rest, '~')
+		p.pos += tildes
+		return &MagicWord{Value: rest[:tildes]}
+
+	case strings.HasPrefix(rest, "__"):
+		if behaviourSwitch := behaviourSwitchAt(rest); behaviourSwitch != "" {
+			p.pos += len(behaviourSwitch)
+			return &MagicWord{Value: behaviourSwitch}
+		}
+
+	case strings.HasPrefix(rest, "[["):
+		return p.parseWikiLink()
+
+	case strings.HasPrefix(rest, "["):
+		return p.parseExternalLink()
+
+	case strings.HasPrefix(rest, "''"):
+		return p.parseQuotes()
+	}
+
+	// Any other tag that is allowed in wikitext (including the end tags).
+	if strings.HasPrefix(rest, "<") {
+		if token, ok := readHtmlTag(rest); ok && contains(wikiHtmlTags, token.Name) {
+			p.pos += len(token.Raw)
+			return &HtmlTag{token.Name, token.Raw}
+		}
+	}
+
+	return nil
+}
+
+func countPrefix(s string, c byte) int {
+	i := 0
+	for i < len(s) && s[i] == c {
+		i++
+	}
+
+	return i
+}
+
+func countSuffix(s string, c byte) int {
+	i := 0
+	for i < len(s) && s[len(s)-1-i] == c {
+		i++
+	}
+
+	return i
+}
+
+func (p *wikiParser) parseHeading() []Node {
+	line := p.restOfLine()
+	trimmed := strings.TrimLeft(line, " \t")
+	indent := line[:len(line)-len(trimmed)]
+
+	// Like MediaWiki, comments and white space can come after the closing
+	// equals signs.
+	body := strings.TrimRight(trimmed, " \t")
+	for strings.HasSuffix(body, "-->") && strings.Contains(body, "<!--") {
+		body = strings.TrimRight(body[:strings.LastIndex(body, "<!--")], " \t")
+	}
+	trailing := trimmed[len(body):]
+
+	level := countPrefix(body, '=')
+	if suffix := countSuffix(body, '='); suffix < level {
+		level = suffix
+	}
+	if level > 6 {
+		level = 6
+	}
+
+	if level == 0 || len(body) <= level*2 {
+		return nil
+	}
+
+	p.pos += len(line)
+
+	return append([]Node{
+		&Text{indent},
+		&Heading{level, p.parseChild(body[level : len(body)-level])},
+	}, p.parseChild(trailing)...)
+}
+
+// listType returns the type of list that a prefix character belongs to, or 0
+// if it is not a prefix character. Terms and definitions are in the same list.
+func listType(c byte) byte {
+	switch c {
+	case '*', '#':
+		return c
+	case ';', ':':
+		return ':'
+	}
+
+	return 0
+}
+
+func listPrefix(line string) string {
+	i := 0
+	for i < len(line) && listType(line[i]) != 0 {
+		i++
+	}
+
+	return line[:i]
+}
+
+// parseList parses the following lines that belong to the same list as the
+// first line. The new line after the last item is left for the caller.
+func (p *wikiParser) parseList() Node {
+	list := &List{}
+	kind := listType(p.input[p.pos])
+
+	for {
+		item := &ListItem{Prefix: listPrefix(p.restOfLine())}
+		p.pos += len(item.Prefix)
+		list.Items = append(list.Items, item)
+
+		// A term can be followed by its definition on the same line.
+		isTerm := item.Prefix[len(item.Prefix)-1] == ';'
+		item.Children = p.parseNodes(func() bool {
+			return p.input[p.pos] == '\n' || (isTerm && p.input[p.pos] == ':')
+		})
+
+		if p.pos < len(p.input) && p.input[p.pos] == ':' {
+			p.pos++
+			definition := &ListItem{Prefix: item.Prefix[:len(item.Prefix)-1] + ":", Inline: true}
+			definition.Children = p.parseNodes(func() bool {
+				return p.input[p.pos] == '\n'
+			})
+			list.Items = append(list.Items, definition)
+		}
+
+		if p.pos+1 >= len(p.input) || listType(p.input[p.pos+1]) != kind {
+			return list
+		}
+
+		p.pos++
+	}
+}
+
+// parseTable parses a {| ... |} block. The block must start and end at the
+// beginning of a line, otherwise nil is returned.
+func (p *wikiParser) parseTable() Node {
+	if !strings.HasPrefix(strings.TrimLeft(p.restOfLine(), " \t"), "{|") {
+		return nil
+	}
+
+	lines := strings.Split(p.input[p.pos:], "\n")
+	depth := 0
+	end := -1
+	for i, line := range lines {
+		line = strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(line, "{|") {
+			depth++
+		} else if strings.HasPrefix(line, "|}") {
+			depth--
+			if depth == 0 {
+				end = i
+				break
+			}
+		}
+	}
+
+	if end < 0 {
+		return nil
+	}
+
+	// Advance past the closing "|}". Anything after it on the same line is
+	// left for the caller.
+	for _, line := range lines[:end] {
+		p.pos += len(line) + 1
+	}
+	p.pos += len(lines[end]) - len(strings.TrimLeft(lines[end], " \t")) + 2
+
+	table := &Table{
+		Attributes: strings.TrimLeft(lines[0], " \t")[2:],
+	}
+
+	// The content of the caption and cells is parsed once all of the lines
+	// that belong to them have been found.
+	var row *TableRow
+	contents := []*[]Node{}
+	bodies := []string{}
+	nested := 0
+
+	for _, line := range lines[1:end] {
+		trimmed := strings.TrimLeft(line, " \t")
+
+		switch {
+		case nested > 0 || strings.HasPrefix(trimmed, "{|"):
+			// A nested table belongs to the cell that it is in.
+			if strings.HasPrefix(trimmed, "{|") {
+				nested++
+			} else if strings.HasPrefix(trimmed, "|}") {
+				nested--
+			}
+
+		case strings.HasPrefix(trimmed, "|+"):
+			caption := &TableCaption{}
+			var body string
+			caption.Attributes, body = p.splitTableCell(trimmed[2:])
+			table.Caption = caption
+			contents = append(contents, &caption.Children)
+			bodies = append(bodies, body)
+			continue
+
+		case strings.HasPrefix(trimmed, "|-"):
+			row = &TableRow{Attributes: trimmed[2:]}
+			table.Rows = append(table.Rows, row)
+			continue
+
+		case strings.HasPrefix(trimmed, "|") || strings.HasPrefix(trimmed, "!"):
+			if row == nil {
+				row = &TableRow{Implicit: true}
+				table.Rows = append(table.Rows, row)
+			}
+
+			header := trimmed[0] == '!'
+			separators, cellsWikitext := p.splitTableCells(trimmed[1:], header)
+			for i, cellWikitext := range cellsWikitext {
+				cell := &TableCell{Header: header, Separator: separators[i]}
+				var body string
+				cell.Attributes, body = p.splitTableCell(cellWikitext)
+				row.Cells = append(row.Cells, cell)
+				contents = append(contents, &cell.Children)
+				bodies = append(bodies, body)
+			}
+			continue
+		}
+
+		// Any other line is a continuation of the previous cell or caption.
+		if len(bodies) > 0 {
+			bodies[len(bodies)-1] += "\n" + line
+		}
+	}
+
+	for i, content := range contents {
+		*content = p.parseChild(bodies[i])
+	}
+
+	return table
+}
+
+// splitTableCells splits a line of table cells. Data cells are separated by
+// || and header cells by either !! or ||. The separator before each cell is
+// returned so that the line can be put back together. It is empty for the
+// first cell.
+func (p *wikiParser) splitTableCells(line string, header bool) (separators, cells []string) {
+	for i, cell := range splitTopLevel(line, "||", 0, p.opaqueTags) {
+		parts := []string{cell}
+		if header {
+			parts = splitTopLevel(cell, "!!", 0, p.opaqueTags)
+		}
+
+		for j, part := range parts {
+			switch {
+			case j > 0:
+				separators = append(separators, "!!")
+			case i > 0:
+				separators = append(separators, "||")
+			default:
+				separators = append(separators, "")
+			}
+			cells = append(cells, part)
+		}
+	}
+
+	return
+}
+
+// splitTableCell separates the attributes of a cell or caption from its
+// content. Like MediaWiki, it is not an attribute if it contains a link.
+func (p *wikiParser) splitTableCell(cell string) (attributes, body string) {
+	parts := p.split(cell, '|', 2)
+	if len(parts) < 2 || strings.Contains(parts[0], "[[") {
+		return "", cell
+	}
+
+	return parts[0], parts[1]
+}
+
+// parseTag parses an extension tag such as <ref> whose body is kept verbatim.
+// Attributes is the raw text after the tag name up to the end of the opening
+// tag.
+func (p *wikiParser) parseTag(name string) (attributes, body string, selfClosing, ok bool) {
+	rest := p.input[p.pos+len(name)+1:]
+	if rest == "" || !strings.ContainsRune(" \t\n/>", rune(rest[0])) {
+		return
+	}
+
+	openEnd := strings.IndexByte(rest, '>')
+	if openEnd < 0 {
+		return
+	}
+
+	if openEnd > 0 && rest[openEnd-1] == '/' {
+		p.pos += len(name) + 1 + openEnd + 1
+		return rest[:openEnd-1], "", true, true
+	}
+
+	closingTag := "</" + name + ">"
+	closeStart := strings.Index(rest[openEnd+1:], closingTag)
+	if closeStart < 0 {
+		return
+	}
+
+	p.pos += len(name) + 1 + openEnd + 1 + closeStart + len(closingTag)
+
+	return rest[:openEnd], rest[openEnd+1 : openEnd+1+closeStart], false, true
+}
+
+// findClosing returns the index of the close delimiter that balances an open
+// delimiter ending just before start, or -1 if there is none.
+func findClosing(s string, start int, open, close string) int {
+	depth := 1
+	for i := start; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], open):
+			depth++
+			i += len(open)
+
+		case strings.HasPrefix(s[i:], close):
+			depth--
+			if depth == 0 {
+				return i
+			}
+			i += len(close)
+
+		default:
+			i++
+		}
+	}
+
+	return -1
+}
+
+// findBracesClosing returns the index of the braces that close a template
+// ({{) or template parameter ({{{) whose inner text starts at start, or -1 if
+// there are none. Like MediaWiki, three braces are a parameter so the braces of
+// {{foo|{{{1}}}}} are balanced.
+func findBracesClosing(s string, start, braces int) int {
+	open := []int{braces}
+	for i := start; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "{{{"):
+			open = append(open, 3)
+			i += 3
+
+		case strings.HasPrefix(s[i:], "{{"):
+			open = append(open, 2)
+			i += 2
+
+		case strings.HasPrefix(s[i:], "}}") && (open[len(open)-1] == 2 || strings.HasPrefix(s[i:], "}}}")):
+			braces := open[len(open)-1]
+			open = open[:len(open)-1]
+			if len(open) == 0 {
+				return i
+			}
+			i += braces
+
+		default:
+			i++
+		}
+	}
+
+	return -1
+}
+
+// splitTopLevel splits s around sep, ignoring any sep that appears inside a
+// link, template, comment, <nowiki> or opaque tag. n has the same meaning as
+// in strings.SplitN.
+func splitTopLevel(s string, sep string, n int, opaqueTags []string) []string {
+	parts := []string{}
+	depth := 0
+	last := 0
+
+	for i := 0; i < len(s); i++ {
+		if n > 0 && len(parts) == n-1 {
+			break
+		}
+
+		rest := s[i:]
+		switch {
+		case strings.HasPrefix(rest, "[[") || strings.HasPrefix(rest, "{{"):
+			depth++
+			i++
+
+		case strings.HasPrefix(rest, "]]") || strings.HasPrefix(rest, "}}"):
+			if depth > 0 {
+				depth--
+			}
+			i++
+
+		case strings.HasPrefix(rest, "<!--"):
+			if end := strings.Index(rest, "-->"); end >= 0 {
+				i += end + len("-->") - 1
+			}
+
+		case tagNameRegexp.MatchString(rest):
+			name := tagNameRegexp.FindStringSubmatch(rest)[1]
+			if name != "nowiki" && !contains(opaqueTags, strings.ToLower(name)) {
+				break
+			}
+
+			openEnd := strings.IndexByte(rest, '>')
+			if openEnd < 0 || rest[openEnd-1] == '/' {
+				break
+			}
+
+			closingTag := "</" + name + ">"
+			if end := strings.Index(rest, closingTag); end >= 0 {
+				i += end + len(closingTag) - 1
+			}
+
+		case strings.HasPrefix(rest, sep) && depth == 0:
+			parts = append(parts, s[last:i])
+			last = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+
+	return append(parts, s[last:])
+}
+
+// parseParameter parses a template parameter such as {{{1|default}}}. It can
+// only be replaced by MediaWiki when the page is used as a template, so it is
+// kept as it was.
+func (p *wikiParser) parseParameter() Node {
+	end := findBracesClosing(p.input, p.pos+3, 3)
+	if end < 0 {
+		return nil
+	}
+
+	parameter := &MagicWord{Value: p.input[p.pos : end+3]}
+	p.pos = end + 3
+
+	return parameter
+}
+
+func (p *wikiParser) parseTemplate() Node {
+	end := findBracesClosing(p.input, p.pos+2, 2)
+	if end < 0 {
+		return nil
+	}
+
+	parts := p.split(p.input[p.pos+2:end], '|', -1)
+	if isPageMagicWord(parts[0]) {
+		magicWord := &MagicWord{Value: p.input[p.pos : end+2]}
+		p.pos = end + 2
+		return magicWord
+	}
+
+	if function := p.parseParserFunction(parts); function != nil {
+		p.pos = end + 2
+		return function
+	}
+
+	// The name keeps its whitespace (such as the newline after the name of an
+	// infobox) so that the template is written back exactly as it was.
+	template := &Template{Name: parts[0]}
+	if strings.TrimSpace(template.Name) == "" {
+		return nil
+	}
+
+	for _, param := range parts[1:] {
+		name, value := splitArg(param, p.opaqueTags)
+		template.Args = append(template.Args, &Arg{Name: name, Children: p.parseChild(value)})
+	}
+
+	p.pos = end + 2
+
+	return template
+}
+
+// behaviourSwitchAt returns the behaviour switch at the start of s, or an empty
+// string if there is not one.
+func behaviourSwitchAt(s string) string {
+	for _, name := range caseSensitiveBehaviourSwitches {
+		if strings.HasPrefix(s, "__"+name+"__") {
+			return s[:len(name)+4]
+		}
+	}
+
+	for _, name := range behaviourSwitches {
+		if len(s) >= len(name)+4 && strings.EqualFold(s[:len(name)+4], "__"+name+"__") {
+			return s[:len(name)+4]
+		}
+	}
+
+	return ""
+}
+
+// languageLinkAt returns the language code if the target of a link starts with
+// one of the languageCodes followed by a colon.
+func languageLinkAt(target string) string {
+	colon := strings.IndexByte(target, ':')
+	if colon > 0 && contains(languageCodes, target[:colon]) {
+		return target[:colon]
+	}
+
+	return ""
+}
+
+// isPageMagicWord returns true if the name of a template is one of the
+// pageMagicWords followed by a colon.
+func isPageMagicWord(name string) bool {
+	colon := strings.IndexByte(name, ':')
+
+	return colon >= 0 && contains(pageMagicWords, strings.TrimSpace(name[:colon]))
+}
+
+// translateDisplayTitles makes the title of each {{DISPLAYTITLE:...}}
+// Translatable. A DISPLAYTITLE with arguments (like |noreplace) stays hidden.
+func translateDisplayTitles(nodes []Node, opaqueTags []string) {
+	walkNodes(nodes, func(node Node) bool {
+		magicWord, ok := node.(*MagicWord)
+		if !ok || magicWord.Translatable || !strings.HasPrefix(magicWord.Value, "{{") {
+			return true
+		}
+
+		inner := magicWord.Value[2 : len(magicWord.Value)-2]
+		colon := strings.IndexByte(inner, ':')
+		if strings.TrimSpace(inner[:colon]) != "DISPLAYTITLE" || len(splitTopLevel(inner, "|", -1, opaqueTags)) > 1 {
+			return true
+		}
+
+		magicWord.Value = "{{" + inner[:colon+1]
+		magicWord.Children = parseFragment(inner[colon+1:], opaqueTags)
+		magicWord.Translatable = true
+
+		return true
+	})
+}
+
+// showComments makes all of the comments Visible.
+func showComments(nodes []Node) {
+	walkNodes(nodes, func(node Node) bool {
+		if comment, ok := node.(*Comment); ok {
+			comment.Visible = true
+		}
+
+		return true
+	})
+}
+
+// parseParserFunction returns nil if the parts of a template are not a parser
+// function. The arguments are kept exactly as they were written because the
+// whitespace can be significant.
+func (p *wikiParser) parseParserFunction(parts []string) *ParserFunction {
+	colon := strings.IndexByte(parts[0], ':')
+	if colon < 0 || !parserFunctionNameRegexp.MatchString(parts[0][:colon]) {
+		return nil
+	}
+
+	function := &ParserFunction{Name: parts[0][:colon], Expression: parts[0][colon+1:]}
+	name := strings.ToLower(strings.TrimSpace(function.Name))
+
+	for i, param := range parts[1:] {
+		arg := &Arg{Children: p.parseChild(param)}
+
+		switch {
+		case !contains(parserFunctionOutputs, name):
+			arg.Hidden = true
+
+		case name == "#ifeq" && i == 0:
+			// The value to compare with the expression.
+			arg.Hidden = true
+
+		case name == "#switch":
+			if kv := p.split(param, '=', 2); len(kv) == 2 {
+				arg.Name, arg.Children = kv[0], p.parseChild(kv[1])
+			} else if i < len(parts)-2 {
+				// A case without a value falls through to the next case. Only
+				// the last argument can be the default value.
+				arg.Hidden = true
+			}
+		}
+
+		if arg.Hidden {
+			arg.Children = []Node{&Text{param}}
+		}
+
+		function.Args = append(function.Args, arg)
+	}
+
+	return function
+}
+
+// splitArg splits a template argument (without the leading |) into its name and
+// value. Like MediaWiki, the first "=" that is not inside of a link, template
+// or tag ends the name. The name will be empty for a positional argument. The
+// name is not trimmed so that aligned arguments (like "| name   = value") keep
+// their layout.
+func splitArg(param string, opaqueTags []string) (name, value string) {
+	kv := splitTopLevel(param, "=", 2, opaqueTags)
+	if len(kv) < 2 {
+		return "", param
+	}
+
+	return kv[0], kv[1]
+}
+
+func (p *wikiParser) parseWikiLink() Node {
+	end := findClosing(p.input, p.pos+2, "[[", "]]")
+	if end < 0 || end == p.pos+2 {
+		return nil
+	}
+
+	inner := p.input[p.pos+2 : end]
+	p.pos = end + 2
+
+	if namespace := categoryRegexp.FindString(inner); namespace != "" {
+		parts := p.split(inner[len(namespace):], '|', 2)
+		category := &Category{Namespace: namespace[:len(namespace)-1], Name: parts[0]}
+		if len(parts) > 1 {
+			category.SortKey = "|" + parts[1]
+		}
+
+		return category
+	}
+
+	if namespace := imageNamespaceRegexp.FindString(inner); namespace != "" {
+		return p.parseImage(namespace[:len(namespace)-1], inner[len(namespace):])
+	}
+
+	if language := languageLinkAt(inner); language != "" {
+		return &LanguageLink{language, inner[len(language)+1:]}
+	}
+
+	parts := p.split(inner, '|', 2)
+	link := &Link{
+		Target:   parts[0],
+		External: isAnExternalURL(parts[0]),
+	}
+
+	if len(parts) > 1 {
+		link.Children = p.parseChild(parts[1])
+	} else if !link.External {
+		link.Children = []Node{&Text{parts[0]}}
+	}
+
+	return link
+}
+
+// parseImage parses the inside of an image link after the namespace. Like
+// MediaWiki, the caption is the last option that is not recognised.
+func (p *wikiParser) parseImage(namespace, inner string) *Image {
+	parts := p.split(inner, '|', 0)
+	image := &Image{Namespace: namespace, Source: parts[0]}
+
+	caption := -1
+	for i, part := range parts {
+		if i > 0 && !imageOptionRegexp.MatchString(part) && !imageAltRegexp.MatchString(part) {
+			caption = i
+		}
+	}
+
+	for i, part := range parts[1:] {
+		switch {
+		case imageAltRegexp.MatchString(part):
+			kv := strings.SplitN(part, "=", 2)
+			image.Options = append(image.Options, &ImageOption{Name: kv[0], Children: p.parseChild(kv[1])})
+
+		case i+1 == caption:
+			image.Options = append(image.Options, &ImageOption{Children: p.parseChild(part)})
+
+		default:
+			image.Options = append(image.Options, &ImageOption{Children: []Node{&Text{part}}, Hidden: true})
+		}
+	}
+
+	return image
+}
+
+func (p *wikiParser) parseExternalLink() Node {
+	end := strings.IndexAny(p.input[p.pos:], "]\n")
+	if end < 0 || p.input[p.pos+end] != ']' {
+		return nil
+	}
+
+	parts := strings.SplitN(p.input[p.pos+1:p.pos+end], " ", 2)
+	if !isAnExternalURL(parts[0]) {
+		return nil
+	}
+
+	p.pos += end + 1
+
+	link := &Link{Target: parts[0], External: true}
+	if len(parts) > 1 {
+		link.Children = p.parseChild(parts[1])
+	}
+
+	return link
+}
+
+// parseQuotes parses a run of apostrophes. Like MediaWiki, a run of four is an
+// apostrophe followed by bold and a run of more than five is apostrophes
+// followed by bold and italic. The runs are turned into Bold and Italic by
+// applyQuotes once the whole line has been parsed.
+func (p *wikiParser) parseQuotes() Node {
+	run := countPrefix(p.input[p.pos:], '\'')
+
+	switch {
+	case run == 4:
+		p.pos++
+		return &Text{"'"}
+
+	case run > 5:
+		p.pos += run - 5
+		return &Text{strings.Repeat("'", run-5)}
+	}
+
+	p.pos += run
+	return &quoteRun{run}
+}
@@ -0,0 +1,44 @@
+package wikitext
+
+import (
+	"reflect"
+	"testing"
+)
+
+type splitTopLevelExample struct {
+	s        string
+	n        int
+	expected []string
+}
+
+var splitTopLevelExamples = []splitTopLevelExample{
+	{"foo", -1, []string{"foo"}},
+	{"foo|bar|baz", -1, []string{"foo", "bar", "baz"}},
+	{"foo|bar|baz", 2, []string{"foo", "bar|baz"}},
+	{"foo|[[bar|baz]]", -1, []string{"foo", "[[bar|baz]]"}},
+	{"foo|{{bar|{{baz|qux}}}}|abc", -1, []string{"foo", "{{bar|{{baz|qux}}}}", "abc"}},
+	{"foo|<nowiki>|</nowiki>|bar", -1, []string{"foo", "<nowiki>|</nowiki>", "bar"}},
+}
+
+func TestSplitTopLevel(t *testing.T) {
+	for _, test := range splitTopLevelExamples {
+		result := splitTopLevel(test.s, "|", test.n, defaultOpaqueTags)
+		if !reflect.DeepEqual(test.expected, result) {
+			t.Errorf("Expected %#v, got %#v", test.expected, result)
+		}
+	}
+}
+
+func TestParseWikiNesting(t *testing.T) {
+	nodes := ParseWiki("{{foo|a=''bar|baz''}}")
+	expected := []Node{
+		&Template{"foo", []*Arg{
+			{"a", []Node{&Italic{[]Node{&Text{"bar"}}, true}}, false},
+			{"", []Node{&Text{"baz"}, &Italic{[]Node{}, true}}, false},
+		}},
+	}
+
+	if !reflect.DeepEqual(expected, nodes) {
+		t.Errorf("Expected %#v, got %#v", expected, nodes)
+	}
+}
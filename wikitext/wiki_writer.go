@@ -0,0 +1,267 @@
+package wikitext
+
+import (
+	"bytes"
+	"strings"
+)
+
+// RenderWiki converts a document tree back into wikitext.
+func RenderWiki(nodes []Node) string {
+	buf := new(bytes.Buffer)
+	writeWikiNodes(buf, nodes)
+
+	return buf.String()
+}
+
+// writeWikiNodes writes the nodes. Bold and Italic are written the way that
+// MediaWiki reads them, each run of apostrophes toggles bold, italic or both.
+func writeWikiNodes(buf *bytes.Buffer, nodes []Node) {
+	w := &quoteWriter{buf: buf}
+	w.writeNodes(nodes, false, false)
+	w.endLine()
+}
+
+// quoteWriter keeps track of the bold and italic that have been written. empty
+// is true when nothing has been written since the last run of apostrophes.
+// unclosedBold and unclosedItalic are true when the bold or italic that is open
+// came from an Unclosed node, so it is not closed at the end of the line.
+type quoteWriter struct {
+	buf                          *bytes.Buffer
+	bold, italic, empty          bool
+	unclosedBold, unclosedItalic bool
+}
+
+func (w *quoteWriter) writeNodes(nodes []Node, bold, italic bool) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *Bold:
+			if len(n.Children) == 0 {
+				w.toggle(true, italic)
+			}
+			w.writeNodes(n.Children, true, italic)
+			w.unclosedBold = w.unclosedBold || n.Unclosed
+
+		case *Italic:
+			if len(n.Children) == 0 {
+				w.toggle(bold, true)
+			}
+			w.writeNodes(n.Children, bold, true)
+			w.unclosedItalic = w.unclosedItalic || n.Unclosed
+
+		case *Text:
+			for i, line := range strings.Split(n.Value, "\n") {
+				if i > 0 {
+					w.endLine()
+					w.buf.WriteString("\n")
+				}
+				if line != "" {
+					w.toggle(bold, italic)
+					w.buf.WriteString(line)
+					w.empty = false
+				}
+			}
+
+		default:
+			w.toggle(bold, italic)
+			writeWikiNode(w.buf, node)
+			w.empty = false
+		}
+	}
+}
+
+func (w *quoteWriter) toggle(bold, italic bool) {
+	switch {
+	case bold != w.bold && italic != w.italic:
+		w.buf.WriteString("'''''")
+	case bold != w.bold:
+		w.buf.WriteString("'''")
+	case italic != w.italic:
+		w.buf.WriteString("''")
+	default:
+		return
+	}
+
+	w.bold, w.italic, w.empty = bold, italic, true
+	w.unclosedBold, w.unclosedItalic = false, false
+}
+
+// endLine closes bold and italic at the end of a line. Like MediaWiki, they do
+// not need to be closed if nothing came after them. Unclosed bold and italic
+// are left the way they were written.
+func (w *quoteWriter) endLine() {
+	if !w.empty {
+		w.toggle(w.bold && w.unclosedBold, w.italic && w.unclosedItalic)
+	}
+	w.bold, w.italic, w.empty = false, false, false
+	w.unclosedBold, w.unclosedItalic = false, false
+}
+
+func writeWikiNode(buf *bytes.Buffer, node Node) {
+	switch n := node.(type) {
+	case *Text:
+		buf.WriteString(n.Value)
+
+	case *Bold, *Italic:
+		writeWikiNodes(buf, []Node{n})
+
+	case *Link:
+		label := RenderWiki(n.Children)
+		switch {
+		case n.External && (label == "" || label == n.Target):
+			buf.WriteString("[" + n.Target + "]")
+		case n.External:
+			buf.WriteString("[" + n.Target + " " + label + "]")
+		case label == "" || label == n.Target:
+			buf.WriteString("[[" + n.Target + "]]")
+		default:
+			buf.WriteString("[[" + n.Target + "|" + label + "]]")
+		}
+
+	case *Image:
+		buf.WriteString("[[" + n.Namespace + ":" + n.Source)
+		for _, option := range n.Options {
+			writeWikiNode(buf, option)
+		}
+		buf.WriteString("]]")
+
+	case *ImageOption:
+		buf.WriteString("|")
+		if n.Name != "" {
+			buf.WriteString(n.Name + "=")
+		}
+		writeWikiNodes(buf, n.Children)
+
+	case *Category:
+		buf.WriteString("[[" + n.Namespace + ":" + n.Name + n.SortKey + "]]")
+
+	case *LanguageLink:
+		buf.WriteString("[[" + n.Language + ":" + n.Title + "]]")
+
+	case *Template:
+		buf.WriteString("{{" + n.Name)
+		for _, arg := range n.Args {
+			writeWikiNode(buf, arg)
+		}
+		buf.WriteString("}}")
+
+	case *ParserFunction:
+		buf.WriteString("{{" + n.Name + ":" + n.Expression)
+		for _, arg := range n.Args {
+			writeWikiNode(buf, arg)
+		}
+		buf.WriteString("}}")
+
+	case *Arg:
+		buf.WriteString("|")
+		if n.Name != "" {
+			buf.WriteString(n.Name + "=")
+		}
+		writeWikiNodes(buf, n.Children)
+
+	case *MagicWord:
+		buf.WriteString(n.Value)
+		if n.Translatable {
+			writeWikiNodes(buf, n.Children)
+			buf.WriteString("}}")
+		}
+
+	case *Comment:
+		buf.WriteString("<!--" + n.Body + "-->")
+
+	case *Heading:
+		buf.WriteString(strings.Repeat("=", n.Level))
+		writeWikiNodes(buf, n.Children)
+		buf.WriteString(strings.Repeat("=", n.Level))
+
+	case *List:
+		for i, item := range n.Items {
+			if i > 0 && !item.Inline {
+				buf.WriteString("\n")
+			}
+			writeWikiNode(buf, item)
+		}
+
+	case *ListItem:
+		if n.Inline {
+			buf.WriteString(":")
+		} else {
+			buf.WriteString(n.Prefix)
+		}
+		writeWikiNodes(buf, n.Children)
+
+	case *Table:
+		buf.WriteString("{|" + n.Attributes + "\n")
+		if n.Caption != nil {
+			writeWikiNode(buf, n.Caption)
+		}
+		for _, row := range n.Rows {
+			writeWikiNode(buf, row)
+		}
+		buf.WriteString("|}")
+
+	case *TableCaption:
+		buf.WriteString("|+")
+		writeWikiTableAttributes(buf, n.Attributes)
+		writeWikiNodes(buf, n.Children)
+		buf.WriteString("\n")
+
+	case *TableRow:
+		if !n.Implicit {
+			buf.WriteString("|-" + n.Attributes + "\n")
+		}
+		for i, cell := range n.Cells {
+			if i > 0 && cell.Separator != "" {
+				buf.WriteString(cell.Separator)
+				writeWikiTableAttributes(buf, cell.Attributes)
+				writeWikiNodes(buf, cell.Children)
+				continue
+			}
+
+			if i > 0 {
+				buf.WriteString("\n")
+			}
+			writeWikiNode(buf, cell)
+		}
+		if len(n.Cells) > 0 {
+			buf.WriteString("\n")
+		}
+
+	case *TableCell:
+		if n.Header {
+			buf.WriteString("!")
+		} else {
+			buf.WriteString("|")
+		}
+		writeWikiTableAttributes(buf, n.Attributes)
+		writeWikiNodes(buf, n.Children)
+
+	case *Ref:
+		writeWikiTag(buf, "ref", n.Attributes, n.Body, n.SelfClosing)
+
+	case *NoWiki:
+		writeWikiTag(buf, "nowiki", n.Attributes, n.Body, n.SelfClosing)
+
+	case *Tag:
+		writeWikiTag(buf, n.Name, n.Attributes, n.Body, n.SelfClosing)
+
+	case *HtmlTag:
+		buf.WriteString(n.Raw)
+	}
+}
+
+func writeWikiTag(buf *bytes.Buffer, name, attributes, body string, selfClosing bool) {
+	if selfClosing {
+		buf.WriteString("<" + name + attributes + "/>")
+		return
+	}
+
+	buf.WriteString("<" + name + attributes + ">" + body + "</" + name + ">")
+}
+
+// writeWikiTableAttributes writes the attributes of a cell or caption. They are
+// separated from the content by a single pipe.
+func writeWikiTableAttributes(buf *bytes.Buffer, attributes string) {
+	if attributes != "" {
+		buf.WriteString(attributes + "|")
+	}
+}
@@ -0,0 +1,78 @@
+// Package wikitext converts wikitext (the markup used by Wikipedia) into
+// formats that can be translated with CAT tools, and back again.
+//
+// The main format is a pseudo-HTML where each wiki construct is an HTML element
+// that the CAT tool will leave alone. XLIFF and TMX are also supported.
+package wikitext
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Version is the version of wikitranslate.
+const Version = "0.2.1"
+
+func isAnExternalURL(url string) bool {
+	return strings.Contains(url, "://")
+}
+
+func BalanceHtmlTags(html string) string {
+	parts := []string{}
+	result := ""
+	split1 := strings.Split(html, "<")
+	for _, p := range split1 {
+		parts = append(parts, strings.Split(p, ">")...)
+	}
+
+	stack := []string{}
+	for i := 0; i < len(parts)-1; i += 2 {
+		result += parts[i]
+
+		if len(parts[i+1]) > 0 && parts[i+1][0] == '/' {
+			for j := len(stack) - 1; j >= 0; j-- {
+				s := stack[j]
+				stack = stack[:len(stack)-1]
+				result += fmt.Sprintf("</%v>", s)
+				if parts[i+1] == ("/" + s) {
+					break
+				}
+			}
+		} else {
+			// Void and self-closing tags (like <br>) have nothing to close.
+			tagParts := strings.Split(parts[i+1], " ")
+			if !strings.HasSuffix(parts[i+1], "/") && !contains(htmlVoidElements, strings.ToLower(tagParts[0])) {
+				stack = append(stack, tagParts[0])
+			}
+			result += fmt.Sprintf("<%v>", parts[i+1])
+		}
+	}
+
+	result += parts[len(parts)-1]
+
+	// Anything left on the stack has to be closed.
+	for _, s := range stack {
+		result += fmt.Sprintf("</%v>", s)
+	}
+
+	return result
+}
+
+// WikiToHtml converts wikitext into the pseudo-HTML that is given to the CAT
+// tools.
+func WikiToHtml(wikimarkup string) string {
+	return BalanceHtmlTags(RenderHtml(ParseWiki(wikimarkup)))
+}
+
+// HtmlToWiki converts the pseudo-HTML created by WikiToHtml (after it has been
+// translated) back into wikitext. Any wiki markup that was typed by the
+// translator is escaped so that it appears on the page as text.
+func HtmlToWiki(html string) (string, error) {
+	wiki := new(bytes.Buffer)
+	if err := (&Converter{}).ToWiki(strings.NewReader(html), wiki, Options{}); err != nil {
+		return "", err
+	}
+
+	return wiki.String(), nil
+}
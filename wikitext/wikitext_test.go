@@ -0,0 +1,506 @@
+package wikitext
+
+import (
+	"testing"
+)
+
+type example struct {
+	name    string
+	wiki    string
+	html    string
+	newWiki string
+}
+
+var examples = []example{
+	// <em> and <strong>
+	{"f101", "foo ''bar'' baz", "foo <em>bar</em> baz", ""},
+	{"f102", "foo ''bar'' ''baz'' qux", "foo <em>bar</em> <em>baz</em> qux", ""},
+	{"f103", "foo '''bar''' baz", "foo <strong>bar</strong> baz", ""},
+	{"f104", "foo '''bar''' '''baz''' qux", "foo <strong>bar</strong> <strong>baz</strong> qux", ""},
+	{"f105", "foo '''''bar''''' baz", "foo <strong><em>bar</em></strong> baz", ""},
+	{"f106", "foo ''bar baz", `foo <em unclosed="true">bar baz</em>`, ""},
+	{"f107", "foo '''bar baz", `foo <strong unclosed="true">bar baz</strong>`, ""},
+
+	{"f201", "de l'''homme'' est", `de l'<em>homme</em> est`, ""},
+	{"f202", "'''foo'' bar", `'<em>foo</em> bar`, ""},
+	{"f203", "a ''' b'' c", `a '<em> b</em> c`, ""},
+	{"f204", "foo ''''bar''''", `foo '<strong>bar'</strong>`, ""},
+	{"f205", "foo ''''''bar''''''", `foo '<strong><em>bar'</em></strong>`, ""},
+	{"f206", "It's ''Bob's'' dog", `It's <em>Bob's</em> dog`, ""},
+
+	{"f301", "'''''a''b'''", `<strong><em>a</em>b</strong>`, ""},
+	{"f302", "'''''a'''b''", `<em><strong>a</strong>b</em>`, ""},
+	{"f303", "''a '''b''' c''", `<em>a <strong>b</strong> c</em>`, ""},
+	{"f304", "'''a ''b''' c''", `<strong>a <em>b</em></strong><em> c</em>`, ""},
+	{"f305", "''a'''''b'''", `<em>a</em><strong>b</strong>`, ""},
+	{"f306", "''a [[b|'''c''']] d''", `<em>a <a href="b"><strong>c</strong></a> d</em>`, ""},
+
+	{"f401", "foo '''bar\nbaz'''", "foo <strong unclosed=\"true\">bar</strong>\nbaz<strong unclosed=\"true\"></strong>", ""},
+	{"f402", "foo ''", `foo <em unclosed="true"></em>`, ""},
+	{"f403", "foo '''''", `foo <strong unclosed="true"><em unclosed="true"></em></strong>`, ""},
+	{"f404", "'''''a\n'''a ''b''\n''a '''b'''", "<strong unclosed=\"true\"><em unclosed=\"true\">a</em></strong>\n<strong unclosed=\"true\">a <em>b</em></strong>\n<em unclosed=\"true\">a <strong>b</strong></em>", ""},
+
+	// Links
+	{"l101", "foo [[Bar]] baz", `foo <a href="Bar">Bar</a> baz`, ""},
+	{"l102", "foo [[Bar|some label]] baz", `foo <a href="Bar">some label</a> baz`, ""},
+	{"l103", "foo [[Bar|some label|foo]] baz", `foo <a href="Bar">some label|foo</a> baz`, ""},
+
+	// External links
+	{"l201",
+		"foo [[https://foo.bar/nowhere?query=string#fragment]] baz",
+		`foo <a href="https://foo.bar/nowhere?query=string#fragment"></a> baz`,
+		"foo [https://foo.bar/nowhere?query=string#fragment] baz"},
+	{"l202",
+		"foo [[https://foo.bar/nowhere?query=string#fragment|some label]] baz",
+		`foo <a href="https://foo.bar/nowhere?query=string#fragment">some label</a> baz`,
+		"foo [https://foo.bar/nowhere?query=string#fragment some label] baz"},
+	{"l203",
+		"foo [[https://foo.bar/nowhere?query=string#fragment|some label|foo]] baz",
+		`foo <a href="https://foo.bar/nowhere?query=string#fragment">some label|foo</a> baz`,
+		"foo [https://foo.bar/nowhere?query=string#fragment some label|foo] baz"},
+
+	{"l301",
+		"foo [https://foo.bar/nowhere?query=string#fragment] baz",
+		`foo <a href="https://foo.bar/nowhere?query=string#fragment"></a> baz`,
+		""},
+	{"l302",
+		"foo [https://foo.bar/nowhere?query=string#fragment some label] baz",
+		`foo <a href="https://foo.bar/nowhere?query=string#fragment">some label</a> baz`,
+		""},
+
+	// Images
+	{"i101",
+		"foo [[File:filename.extension]] baz",
+		`foo <img namespace="File" src="filename.extension"></img> baz`,
+		""},
+	{"i102",
+		"foo [[File:filename.extension|thumb]] baz",
+		`foo <img namespace="File" src="filename.extension"><option data="dGh1bWI="></option></img> baz`,
+		""},
+	{"i103",
+		"foo [[File:filename.extension|thumb|caption words]] baz",
+		`foo <img namespace="File" src="filename.extension"><option data="dGh1bWI="></option><option name="">caption words</option></img> baz`,
+		""},
+	{"i104",
+		"foo [[File:filename.extension|thumb|link=Internal]] baz",
+		`foo <img namespace="File" src="filename.extension"><option data="dGh1bWI="></option><option data="bGluaz1JbnRlcm5hbA=="></option></img> baz`,
+		""},
+	{"i105",
+		"foo [[Image:x.jpg|left|link=http://External|caption]] baz",
+		`foo <img namespace="Image" src="x.jpg"><option data="bGVmdA=="></option><option data="bGluaz1odHRwOi8vRXh0ZXJuYWw="></option><option name="">caption</option></img> baz`,
+		""},
+	{"i106",
+		"[[File:x.jpg|first|thumb|second]]",
+		`<img namespace="File" src="x.jpg"><option data="Zmlyc3Q="></option><option data="dGh1bWI="></option><option name="">second</option></img>`,
+		""},
+
+	{"i201",
+		"[[File:x.jpg|thumb|right|250px|alt=A ''dog''|Caption with [[link]] and {{lang|fr|chien}}]]",
+		`<img namespace="File" src="x.jpg"><option data="dGh1bWI="></option><option data="cmlnaHQ="></option><option data="MjUwcHg="></option><option name="alt">A <em>dog</em></option><option name="">Caption with <a href="link">link</a> and <template name="lang"><arg name="">fr</arg><arg name="">chien</arg></template></option></img>`,
+		""},
+
+	{"i301",
+		"[[Datei:x.jpg|miniatur|Bildunterschrift]] [[Fichier:y.png|upright=1.2|légende]]",
+		`<img namespace="Datei" src="x.jpg"><option data="bWluaWF0dXI="></option><option name="">Bildunterschrift</option></img> <img namespace="Fichier" src="y.png"><option data="dXByaWdodD0xLjI="></option><option name="">légende</option></img>`,
+		""},
+	{"i302",
+		"[[:File:x.jpg|link]]",
+		`<a href=":File:x.jpg">link</a>`,
+		""},
+
+	// References
+	{"r101",
+		"foo <ref>[[ABC]]</ref> baz", `foo <ref data="W1tBQkNdXQ=="></ref> baz`,
+		""},
+	{"r102",
+		`foo <ref name="qux">[[ABC]]</ref> baz`,
+		`foo <ref data="W1tBQkNdXQ==" attributes="IG5hbWU9InF1eCI="></ref> baz`,
+		""},
+	{"r103",
+		`foo <ref name="qux" /> baz`,
+		`foo <ref data="" attributes="IG5hbWU9InF1eCIg" selfclosing="true"></ref> baz`,
+		""},
+	{"r104",
+		`foo <ref name=qux/> baz`,
+		`foo <ref data="" attributes="IG5hbWU9cXV4" selfclosing="true"></ref> baz`,
+		""},
+	{"r105",
+		`foo <ref name= qux /> baz`,
+		`foo <ref data="" attributes="IG5hbWU9IHF1eCA=" selfclosing="true"></ref> baz`,
+		""},
+	{"r106",
+		`foo <ref name=Chandler/> <ref name="Hartnagle-Taylor and Ty Taylor"/> baz`,
+		`foo <ref data="" attributes="IG5hbWU9Q2hhbmRsZXI=" selfclosing="true"></ref> <ref data="" attributes="IG5hbWU9IkhhcnRuYWdsZS1UYXlsb3IgYW5kIFR5IFRheWxvciI=" selfclosing="true"></ref> baz`,
+		""},
+	{"r107",
+		`foo <ref name="qux"></ref> baz`,
+		`foo <ref data="" attributes="IG5hbWU9InF1eCI=" selfclosing="false"></ref> baz`,
+		""},
+	// {"r201",
+	// 	`The Smithfield was first introduced to Australia during colonial times.<ref name=Chandler/> It was a handy dog used to work the meat markets in [[Smithfield Meat Market|Smithfield]], London. It is a dog standing from {{Convert|18|to|21|in|cm}}<ref name="Hartnagle-Taylor and Ty Taylor"/> and has a shaggy appearance.`,
+	// 	"",
+	// 	""},
+
+	// <nowiki>
+	{"w101", "foo <nowiki>''qux''</nowiki> baz", `foo <nowiki data="JydxdXgnJw=="></nowiki> baz`, ""},
+	{"w102", "foo <nowiki abc>''qux''</nowiki> baz", `foo <nowiki data="JydxdXgnJw==" attributes="IGFiYw=="></nowiki> baz`, ""},
+	{"w103", "foo<nowiki/>s baz", `foo<nowiki data="" selfclosing="true"></nowiki>s baz`, ""},
+	{"w104", "<nowiki>a\n[[b]]</nowiki>", `<nowiki data="YQpbW2JdXQ=="></nowiki>`, ""},
+	{"w105", "foo<nowiki></nowiki>s baz", `foo<nowiki data="" selfclosing="false"></nowiki>s baz`, ""},
+
+	// Opaque tags
+	{"x101", "a <math display=\"block\">\\frac{a}{b}\n|x|</math> b", `a <tag name="math" data="XGZyYWN7YX17Yn0KfHh8" attributes="IGRpc3BsYXk9ImJsb2NrIg=="></tag> b`, ""},
+	{"x102", "<syntaxhighlight lang=\"go\">\nfunc main() {\n\t''x''\n}\n</syntaxhighlight>\nfoo", "<tag name=\"syntaxhighlight\" data=\"CmZ1bmMgbWFpbigpIHsKCScneCcnCn0K\" attributes=\"IGxhbmc9ImdvIg==\"></tag>\nfoo", ""},
+	{"x103", "* <pre>a\n* b</pre> c", `<ul><li> <tag name="pre" data="YQoqIGI="></tag> c</li></ul>`, ""},
+	{"x104", "{{foo|<math>a|b</math>|c}}", `<template name="foo"><arg name=""><tag name="math" data="YXxi"></tag></arg><arg name="">c</arg></template>`, ""},
+	{"x105", "<MATH>x</MATH>", `<tag name="MATH" data="eA=="></tag>`, ""},
+	{"x106", "<ce>H2O</ce> <templatestyles src=\"a.css\" />", `<tag name="ce" data="SDJP"></tag> <tag name="templatestyles" data="" attributes="IHNyYz0iYS5jc3MiIA==" selfclosing="true"></tag>`, ""},
+	{"x107", "== <math>x</math> ==", `<h2> <tag name="math" data="eA=="></tag> </h2>`, ""},
+	{"x108", `<math alt="a<b">x</math>`, `<tag name="math" data="eA==" attributes="IGFsdD0iYTxiIg=="></tag>`, ""},
+	{"x109", `a<ref name="b&amp;c">d</ref>`, `a<ref data="ZA==" attributes="IG5hbWU9ImImYW1wO2Mi"></ref>`, ""},
+	{"x110", "<ce></ce>", `<tag name="ce" data="" selfclosing="false"></tag>`, ""},
+
+	// Escaping and HTML tags
+	{"e101", "a < b & c > d", `a &lt; b &amp; c &gt; d`, ""},
+	{"e102", "AT&T &nbsp;&amp; <foo>", `AT&amp;T &amp;nbsp;&amp;amp; &lt;foo&gt;`, ""},
+	{"e103", "a<br>b<br />c", `a<br>b<br />c`, ""},
+	{"e104", "x<sup>2</sup> <small>foo</small>", `x<sup>2</sup> <small>foo</small>`, ""},
+	{"e105", "<span style=\"color:red\">foo</span>", `<span style="color:red">foo</span>`, ""},
+	{"e106", "<SMALL>''foo''</SMALL>", `<SMALL><em>foo</em></SMALL>`, ""},
+	{"e107", "<strong>foo</strong>", `<markup data="PHN0cm9uZz4="></markup>foo<markup data="PC9zdHJvbmc+"></markup>`, ""},
+	{"e108", "<small>foo", `<markup data="PHNtYWxsPg=="></markup>foo`, ""},
+	{"e109", "''a <b>b''</b>", `<em>a <markup data="PGI+"></markup>b</em><markup data="PC9iPg=="></markup>`, ""},
+	{"e110", "[[A&B|C&D]] {{a\"b}}", `<a href="A&amp;B">C&amp;D</a> <template name="a&quot;b"></template>`, ""},
+	{"e111", "''''a''' b '''c'''' d ''''''e'''''' f", `'<strong>a</strong> b <strong>c'</strong> d '<strong><em>e'</em></strong> f`, ""},
+
+	// Templates
+	{"t101", "foo {{bar}} baz", `foo <template name="bar"></template> baz`, ""},
+	{"t102",
+		"foo {{bar|qux}} baz",
+		`foo <template name="bar"><arg name="">qux</arg></template> baz`,
+		""},
+	{"t103",
+		"foo {{bar|qux|abc}} baz",
+		`foo <template name="bar"><arg name="">qux</arg><arg name="">abc</arg></template> baz`,
+		""},
+	{"t104",
+		"foo {{bar|qux=abc}} baz",
+		`foo <template name="bar"><arg name="qux">abc</arg></template> baz`,
+		""},
+	{"t105",
+		"foo {{bar|\nqux=abc}} baz",
+		`foo <template name="bar"><arg name="&#10;qux">abc</arg></template> baz`,
+		""},
+	{"t106",
+		"foo {{bar| qux =abc}} baz",
+		`foo <template name="bar"><arg name=" qux ">abc</arg></template> baz`,
+		""},
+	{"t107",
+		"foo {{bar\n|qux=abc}} baz",
+		`foo <template name="bar&#10;"><arg name="qux">abc</arg></template> baz`,
+		""},
+	{"t108",
+		"foo {{bar\n|qux=[[abc|foo]]}} baz",
+		`foo <template name="bar&#10;"><arg name="qux"><a href="abc">foo</a></arg></template> baz`,
+		""},
+	{"t110",
+		"{{T|2+2=4|[[a|b=c]]|{{d|e=f}}|<nowiki>x=y</nowiki>}}",
+		`<template name="T"><arg name="2+2">4</arg><arg name=""><a href="a">b=c</a></arg><arg name=""><template name="d"><arg name="e">f</arg></template></arg><arg name=""><nowiki data="eD15"></nowiki></arg></template>`,
+		""},
+	{"t109",
+		"{{Infobox dog\n| name     = Rex\n| breed    = Terrier\n| image    = \n}}",
+		`<template name="Infobox dog&#10;"><arg name=" name     "> Rex
+</arg><arg name=" breed    "> Terrier
+</arg><arg name=" image    "> 
+</arg></template>`,
+		""},
+
+	// Nested templates
+	{"t201",
+		"foo {{bar|{{qux|xyz}}|a=c}} baz",
+		`foo <template name="bar"><arg name=""><template name="qux"><arg name="">xyz</arg></template></arg><arg name="a">c</arg></template> baz`,
+		""},
+	{"t202",
+		"foo {{bar|{{qux|{{abc|xyz}}}}}} baz",
+		`foo <template name="bar"><arg name=""><template name="qux"><arg name=""><template name="abc"><arg name="">xyz</arg></template></arg></template></arg></template> baz`,
+		""},
+	{"t203",
+		"foo {{bar|''qux''|[[abc|foo]]}} baz",
+		`foo <template name="bar"><arg name=""><em>qux</em></arg><arg name=""><a href="abc">foo</a></arg></template> baz`,
+		""},
+
+	// Parser functions
+	{"p101",
+		"{{#if:{{{1|}}}|yes|no}}",
+		`<function name="#if" data="e3t7MXx9fX0="><arg name="">yes</arg><arg name="">no</arg></function>`,
+		""},
+	{"p102",
+		"foo {{#if: {{{image|}}} | ''An'' image | No image }} bar",
+		`foo <function name="#if" data="IHt7e2ltYWdlfH19fSA="><arg name=""> <em>An</em> image </arg><arg name=""> No image </arg></function> bar`,
+		""},
+	{"p103",
+		"{{#ifeq: {{{lang}}} | en | English | Other }}",
+		`<function name="#ifeq" data="IHt7e2xhbmd9fX0g"><arg name="" data="IGVuIA=="></arg><arg name=""> English </arg><arg name=""> Other </arg></function>`,
+		""},
+	{"p104",
+		"{{#switch: {{{1}}}\n| a = Apple\n| b\n| c = [[Cherry]]\n| #default = None\n}}",
+		"<function name=\"#switch\" data=\"IHt7ezF9fX0K\"><arg name=\" a \"> Apple\n</arg><arg name=\"\" data=\"IGIK\"></arg><arg name=\" c \"> <a href=\"Cherry\">Cherry</a>\n</arg><arg name=\" #default \"> None\n</arg></function>",
+		""},
+	{"p105",
+		"{{#switch:x|a=A|Default}}",
+		`<function name="#switch" data="eA=="><arg name="a">A</arg><arg name="">Default</arg></function>`,
+		""},
+	{"p106",
+		"It is {{#expr: 2 * {{{1}}} }} km",
+		`It is <function name="#expr" data="IDIgKiB7e3sxfX19IA=="></function> km`,
+		""},
+	{"p107",
+		"{{#time:Y|2016-01-01}}",
+		`<function name="#time" data="WQ=="><arg name="" data="MjAxNi0wMS0wMQ=="></arg></function>`,
+		""},
+	{"p108",
+		"{{foo|a={{#if:x|b}}}}",
+		`<template name="foo"><arg name="a"><function name="#if" data="eA=="><arg name="">b</arg></function></arg></template>`,
+		""},
+
+	// Magic words
+	{"m101", "__NOTOC__\nfoo", "<magic data=\"X19OT1RPQ19f\"></magic>\nfoo", ""},
+	{"m102", "foo __NOEDITSECTION__ bar", `foo <magic data="X19OT0VESVRTRUNUSU9OX18="></magic> bar`, ""},
+	{"m103", "foo __bar__", `foo __bar__`, ""},
+	{"m104", "{{DEFAULTSORT:Smith, John}}", `<magic data="e3tERUZBVUxUU09SVDpTbWl0aCwgSm9obn19"></magic>`, ""},
+	{"m105", "{{DISPLAYTITLE:''Foo''}}\nbar", "<magic data=\"e3tESVNQTEFZVElUTEU6JydGb28nJ319\"></magic>\nbar", ""},
+	{"m106", "#REDIRECT [[Foo bar]]", `<magic data="I1JFRElSRUNUIFtbRm9vIGJhcl1d"></magic>`, ""},
+	{"m107", "#redirect:[[Foo]]\n{{R from move}}", "<magic data=\"I3JlZGlyZWN0OltbRm9vXV0=\"></magic>\n<template name=\"R from move\"></template>", ""},
+	{"m108", "foo\n#REDIRECT [[Foo]]", "foo\n<ol><li>REDIRECT <a href=\"Foo\">Foo</a></li></ol>", ""},
+	{"m109", "__notoc__ __HIDDENCAT__ __EXPECTED_UNCONNECTED_PAGE__",
+		`<magic data="X19ub3RvY19f"></magic> <magic data="X19ISURERU5DQVRfXw=="></magic> <magic data="X19FWFBFQ1RFRF9VTkNPTk5FQ1RFRF9QQUdFX18="></magic>`,
+		""},
+	{"m110", "__FOOBAR__ __Hiddencat__ __init__ __TOC", `__FOOBAR__ __Hiddencat__ __init__ __TOC`, ""},
+
+	// Template parameters
+	{"m301", "foo {{{1|x}}} {{{name}}}", `foo <magic data="e3t7MXx4fX19"></magic> <magic data="e3t7bmFtZX19fQ=="></magic>`, ""},
+	{"m302", "{{foo|{{{1}}}}} {{{1|{{{2|{{bar}}}}}}}}", `<template name="foo"><arg name=""><magic data="e3t7MX19fQ=="></magic></arg></template> <magic data="e3t7MXx7e3syfHt7YmFyfX19fX19fX0="></magic>`, ""},
+	{"m303", "{{{1|x}} {{{", `{<template name="1"><arg name="">x</arg></template> {{{`, ""},
+
+	// Signatures and other tildes
+	{"m201", "Thanks ~~~~", `Thanks <magic data="fn5+fg=="></magic>`, ""},
+	{"m202", "~~~ ~~~~~ ~~~~~~~~~~", `<magic data="fn5+"></magic> <magic data="fn5+fn4="></magic> <magic data="fn5+fn5+fn5+fg=="></magic>`, ""},
+	{"m203", "foo ~~1~~ {{bar}} ~~0~~ ~~ ~", `foo ~~1~~ <template name="bar"></template> ~~0~~ ~~ ~`, ""},
+	{"m204", "{{foo|~~1~~|~~~~}} [[~~0~~|~~~~]]", `<template name="foo"><arg name="">~~1~~</arg><arg name=""><magic data="fn5+fg=="></magic></arg></template> <a href="~~0~~"><magic data="fn5+fg=="></magic></a>`, ""},
+	{"m205", "Question? ~~~~\n:Answer. ~~~~\n::~~~~~~ Thanks! ~~~~~", "Question? <magic data=\"fn5+fg==\"></magic>\n<dl><dd>Answer. <magic data=\"fn5+fg==\"></magic><dl><dd><magic data=\"fn5+fn5+\"></magic> Thanks! <magic data=\"fn5+fn4=\"></magic></dd></dl></dd></dl>", ""},
+
+	// Comments
+	{"c101", "foo <!-- bar --> baz", `foo <comment data="IGJhciA="></comment> baz`, ""},
+	{"c102", "<!--\n| population = 12\n-->\nfoo", "<comment data=\"CnwgcG9wdWxhdGlvbiA9IDEyCg==\"></comment>\nfoo", ""},
+	{"c103", "{{foo|a<!-- b|c -->}}", `<template name="foo"><arg name="">a<comment data="IGJ8YyA="></comment></arg></template>`, ""},
+
+	// Categories and interlanguage links
+	{"k101", "Foo\n[[Category:Dog breeds]]", "Foo\n<category data=\"W1tDYXRlZ29yeTpEb2cgYnJlZWRzXV0=\"></category>", ""},
+	{"k102", "[[Category:Terriers|Staffordshire]][[category: Terriers|]]", `<category data="W1tDYXRlZ29yeTpUZXJyaWVyc3xTdGFmZm9yZHNoaXJlXV0="></category><category data="W1tjYXRlZ29yeTogVGVycmllcnN8XV0="></category>`, ""},
+	{"k103", "See [[:Category:Terriers|terriers]]", `See <a href=":Category:Terriers">terriers</a>`, ""},
+	{"k104", "[[fr:Staffordshire bull terrier]]\n[[zh-yue:斯塔福郡鬥牛㹴]]", "<language data=\"W1tmcjpTdGFmZm9yZHNoaXJlIGJ1bGwgdGVycmllcl1d\"></language>\n<language data=\"W1t6aC15dWU65pav5aGU56aP6YOh6ayl54mb47m0XV0=\"></language>", ""},
+	{"k105", "[[simple:Dog]][[be-tarask:Сабака]]", `<language data="W1tzaW1wbGU6RG9nXV0="></language><language data="W1tiZS10YXJhc2s60KHQsNCx0LDQutCwXV0="></language>`, ""},
+	{"k106", "[[doi:10.1000/182]]", `<a href="doi:10.1000/182">doi:10.1000/182</a>`, ""},
+	{"k107", "[[mw:Help:Links|links]]", `<a href="mw:Help:Links">links</a>`, ""},
+	{"k108", "[[wikt:dog]]", `<a href="wikt:dog">wikt:dog</a>`, ""},
+
+	// Headings
+	{"h101", "====== The Heading ======\nbar", "<h6> The Heading </h6>\nbar", ""},
+	{"h102", "===== The Heading =====\nbar", "<h5> The Heading </h5>\nbar", ""},
+	{"h103", "==== The Heading ====\nbar", "<h4> The Heading </h4>\nbar", ""},
+	{"h104", "=== The Heading ===\nbar", "<h3> The Heading </h3>\nbar", ""},
+	{"h105", "== The Heading ==\nbar", "<h2> The Heading </h2>\nbar", ""},
+	{"h106", "= The Heading =\nbar", "<h1> The Heading </h1>\nbar", ""},
+
+	{"h201", " ====== The Heading ======\nbar", " <h6> The Heading </h6>\nbar", ""},
+	{"h202", " ===== The Heading =====\nbar", " <h5> The Heading </h5>\nbar", ""},
+	{"h203", " ==== The Heading ====\nbar", " <h4> The Heading </h4>\nbar", ""},
+	{"h204", " === The Heading ===\nbar", " <h3> The Heading </h3>\nbar", ""},
+	{"h205", " == The Heading ==\nbar", " <h2> The Heading </h2>\nbar", ""},
+	{"h206", " = The Heading =\nbar", " <h1> The Heading </h1>\nbar", ""},
+
+	{"h301", "foo\n====== The Heading ======\nbar", "foo\n<h6> The Heading </h6>\nbar", ""},
+	{"h302", "foo\n===== The Heading =====\nbar", "foo\n<h5> The Heading </h5>\nbar", ""},
+	{"h303", "foo\n==== The Heading ====\nbar", "foo\n<h4> The Heading </h4>\nbar", ""},
+	{"h304", "foo\n=== The Heading ===\nbar", "foo\n<h3> The Heading </h3>\nbar", ""},
+	{"h305", "foo\n== The Heading ==\nbar", "foo\n<h2> The Heading </h2>\nbar", ""},
+	{"h306", "foo\n= The Heading =\nbar", "foo\n<h1> The Heading </h1>\nbar", ""},
+
+	{"h401", "== Foo == <!-- x -->\nbar", "<h2> Foo </h2> <comment data=\"IHgg\"></comment>\nbar", ""},
+	{"h402", "==Foo==<!-- x --> <!--y-->  \nbar", "<h2>Foo</h2><comment data=\"IHgg\"></comment> <comment data=\"eQ==\"></comment>  \nbar", ""},
+	{"h403", "== Foo <!-- x --> ==", `<h2> Foo <comment data="IHgg"></comment> </h2>`, ""},
+
+	// Lists
+	{"o101", "Foo\n* Bar\n* Baz\nQux", "Foo\n<ul><li> Bar</li>\n<li> Baz</li></ul>\nQux", ""},
+	{"o102", "Foo\n# Bar\n# Baz\nQux", "Foo\n<ol><li> Bar</li>\n<li> Baz</li></ol>\nQux", ""},
+	{"o103", "Foo\n*Bar\n*Baz\nQux", "Foo\n<ul><li>Bar</li>\n<li>Baz</li></ul>\nQux", ""},
+	{"o104", "Foo\n#Bar\n#Baz\nQux", "Foo\n<ol><li>Bar</li>\n<li>Baz</li></ol>\nQux", ""},
+	{"o105", "* a\n** b\n*** c\n* d",
+		"<ul><li> a<ul><li> b<ul><li> c</li></ul></li></ul></li>\n<li> d</li></ul>",
+		""},
+	{"o106", "# a\n#* b\n#* c\n# d",
+		"<ol><li> a<ul><li> b</li>\n<li> c</li></ul></li>\n<li> d</li></ol>",
+		""},
+	{"o107", "* a\n# b", "<ul><li> a</li></ul>\n<ol><li> b</li></ol>", ""},
+	{"o108", "; Term\n: Definition\n:: More", "<dl><dt> Term</dt>\n<dd> Definition<dl><dd> More</dd></dl></dd></dl>", ""},
+	{"o109", "; Term : Definition\n;[[a|b]]:c",
+		"<dl><dt> Term </dt><dd inline=\"true\"> Definition</dd>\n<dt><a href=\"a\">b</a></dt><dd inline=\"true\">c</dd></dl>",
+		""},
+	{"o110", "Foo\n: Indented\n:: More", "Foo\n<dl><dd> Indented<dl><dd> More</dd></dl></dd></dl>", ""},
+	{"o111", "#\n#* a\n*; b : c", "<ol><li><ul><li> a</li></ul></li></ol>\n<ul><li implicit=\"true\"><dl><dt> b </dt><dd inline=\"true\"> c</dd></dl></li></ul>", ""},
+	{"o112", "* a\n*** b", "<ul><li> a<ul><li implicit=\"true\"><ul><li> b</li></ul></li></ul></li></ul>", ""},
+
+	// Tables
+	{"g101", "Foo\n{|\n|-\n|Bar\n|}\nQux",
+		"Foo\n<table>\n<tr>\n<td>Bar</td>\n</tr>\n</table>\nQux",
+		""},
+	{"g102", "Foo\n{|\n|-\n|Bar\n|Baz\n|}\nQux",
+		"Foo\n<table>\n<tr>\n<td>Bar</td>\n<td>Baz</td>\n</tr>\n</table>\nQux",
+		""},
+	{"g103", "Foo\n{|\n|-\n|Bar\n|-\n|Baz\n|}\nQux",
+		"Foo\n<table>\n<tr>\n<td>Bar</td>\n</tr>\n<tr>\n<td>Baz</td>\n</tr>\n</table>\nQux",
+		""},
+
+	{"g201", "Foo\n{|\n|Bar\n|}\nQux",
+		"Foo\n<table>\n<tr implicit=\"true\">\n<td>Bar</td>\n</tr>\n</table>\nQux",
+		""},
+	{"g202", "Foo\n{|\n|Bar\n|Baz\n|}\nQux",
+		"Foo\n<table>\n<tr implicit=\"true\">\n<td>Bar</td>\n<td>Baz</td>\n</tr>\n</table>\nQux",
+		""},
+	{"g203", "Foo\n{|\n|Bar\n|-\n|Baz\n|}\nQux",
+		"Foo\n<table>\n<tr implicit=\"true\">\n<td>Bar</td>\n</tr>\n<tr>\n<td>Baz</td>\n</tr>\n</table>\nQux",
+		""},
+
+	{"g301", "Foo\n{|\n!Bar\n|}\nQux",
+		"Foo\n<table>\n<tr implicit=\"true\">\n<th>Bar</th>\n</tr>\n</table>\nQux",
+		""},
+	{"g302", "Foo\n{|\n!Bar\n!Baz\n|}\nQux",
+		"Foo\n<table>\n<tr implicit=\"true\">\n<th>Bar</th>\n<th>Baz</th>\n</tr>\n</table>\nQux",
+		""},
+	{"g303", "Foo\n{|\n!Bar\n|-\n!Baz\n|}\nQux",
+		"Foo\n<table>\n<tr implicit=\"true\">\n<th>Bar</th>\n</tr>\n<tr>\n<th>Baz</th>\n</tr>\n</table>\nQux",
+		""},
+
+	{"g401",
+		"{| class=\"wikitable sortable\"\n|-\n|Bar\n|}",
+		"<table data=\"IGNsYXNzPSJ3aWtpdGFibGUgc29ydGFibGUi\">\n<tr>\n<td>Bar</td>\n</tr>\n</table>",
+		""},
+	{"g402",
+		"{|\n|- style=\"color: red\"\n| style=\"x\" | Bar\n! style=\"y\" | [[a|b]]\n|}",
+		"<table>\n<tr data=\"IHN0eWxlPSJjb2xvcjogcmVkIg==\">\n<td data=\"IHN0eWxlPSJ4IiA=\"> Bar</td>\n<th data=\"IHN0eWxlPSJ5IiA=\"> <a href=\"a\">b</a></th>\n</tr>\n</table>",
+		""},
+	{"g403",
+		"{|\n|-\n| rowspan=\"2\" | a\n| colspan=2 | b\n|}",
+		"<table>\n<tr>\n<td data=\"IHJvd3NwYW49IjIiIA==\" rowspan=\"2\"> a</td>\n<td data=\"IGNvbHNwYW49MiA=\" colspan=\"2\"> b</td>\n</tr>\n</table>",
+		""},
+	{"g404",
+		"{|\n|-\n| [[a|b]] | c\n|}",
+		"<table>\n<tr>\n<td> <a href=\"a\">b</a> | c</td>\n</tr>\n</table>",
+		""},
+
+	{"g501",
+		"{|\n|+ Caption\n|-\n|Bar\n|}",
+		"<table>\n<caption> Caption</caption>\n<tr>\n<td>Bar</td>\n</tr>\n</table>",
+		""},
+	{"g502",
+		"{|\n|+ style=\"x\" | ''Caption''\nmore\n|-\n|Bar\n|}",
+		"<table>\n<caption data=\"IHN0eWxlPSJ4IiA=\"> <em>Caption</em>\nmore</caption>\n<tr>\n<td>Bar</td>\n</tr>\n</table>",
+		""},
+
+	{"g601",
+		"{|\n|-\n| a || b || c\n|}",
+		"<table>\n<tr>\n<td> a </td>\n<td separator=\"||\"> b </td>\n<td separator=\"||\"> c</td>\n</tr>\n</table>",
+		""},
+	{"g602",
+		"{|\n|-\n! a !! b || c\n|}",
+		"<table>\n<tr>\n<th> a </th>\n<th separator=\"!!\"> b </th>\n<th separator=\"||\"> c</th>\n</tr>\n</table>",
+		""},
+	{"g603",
+		"{|\n|-\n| x=1 | a || [[b|c]] || {{d|e}} || <math>f||g</math>\n|}",
+		"<table>\n<tr>\n<td data=\"IHg9MSA=\"> a </td>\n<td separator=\"||\"> <a href=\"b\">c</a> </td>\n<td separator=\"||\"> <template name=\"d\"><arg name=\"\">e</arg></template> </td>\n<td separator=\"||\"> <tag name=\"math\" data=\"Znx8Zw==\"></tag></td>\n</tr>\n</table>",
+		""},
+	{"g604",
+		"{|\n! a | b || c=1 | d\n|-\n| e || f\n| g\n|}",
+		"<table>\n<tr implicit=\"true\">\n<th data=\"IGEg\"> b </th>\n<th data=\"IGM9MSA=\" separator=\"||\"> d</th>\n</tr>\n<tr>\n<td> e </td>\n<td separator=\"||\"> f</td>\n<td> g</td>\n</tr>\n</table>",
+		""},
+
+	{"g701",
+		"{|\n|-\n| a\n{| class=\"inner\"\n|-\n| b\n|}\n| c\n|}",
+		"<table>\n<tr>\n<td> a\n<table data=\"IGNsYXNzPSJpbm5lciI=\">\n<tr>\n<td> b</td>\n</tr>\n</table></td>\n<td> c</td>\n</tr>\n</table>",
+		""},
+}
+
+func TestExamples(t *testing.T) {
+	for _, test := range examples {
+		if test.newWiki == "" {
+			test.newWiki = test.wiki
+		}
+
+		html := WikiToHtml(test.wiki)
+		if html != test.html {
+			t.Errorf("%v:\n  expected HTML: '%v'\n      from wiki: '%v'\n            got: '%v'\n\n",
+				test.name, test.html, test.wiki, html)
+		}
+
+		wiki, err := HtmlToWiki(test.html)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if wiki != test.newWiki {
+			t.Errorf("%v:\n  expected wiki: '%v'\n      from HTML: '%v'\n            got: '%v'\n\n",
+				test.name, test.newWiki, test.html, wiki)
+		}
+	}
+}
+
+// roundTrip converts wikitext to HTML and back again.
+func roundTrip(t *testing.T, wiki string) string {
+	result, err := HtmlToWiki(WikiToHtml(wiki))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+type balanceHtmlTagsExample struct {
+	before   string
+	expected string
+}
+
+var balanceHtmlTagsExamples = []balanceHtmlTagsExample{
+	// Already balanced
+	{"foo bar", "foo bar"},
+	{"foo <bar>bar</bar> baz", "foo <bar>bar</bar> baz"},
+	{"foo <bar a=1>bar</bar> baz", "foo <bar a=1>bar</bar> baz"},
+	{"foo <qux><bar>bar</bar><bar>quxx</bar></qux> baz", "foo <qux><bar>bar</bar><bar>quxx</bar></qux> baz"},
+
+	// Too many opens
+	{"foo <bar>bar baz", "foo <bar>bar baz</bar>"},
+	{"foo <bar a=1>bar baz", "foo <bar a=1>bar baz</bar>"},
+	{"foo <bar>bar <qux>baz", "foo <bar>bar <qux>baz</bar></qux>"},
+	{"foo <bar><qux>bar baz", "foo <bar><qux>bar baz</bar></qux>"},
+	{"foo <bar><qux>bar</bar> baz", "foo <bar><qux>bar</qux></bar> baz"},
+	{"foo <bar><qux>bar<abc></bar> baz", "foo <bar><qux>bar<abc></abc></qux></bar> baz"},
+
+	// To many closes
+	{"foo bar</bar> baz", "foo bar baz"},
+	{"foo bar</bar> baz</foo>", "foo bar baz"},
+	{"foo bar</bar> <abc>baz</foo>", "foo bar <abc>baz</abc>"},
+
+	// Void and self-closing tags
+	{"foo<br>bar", "foo<br>bar"},
+	{"foo<BR/>bar<hr>", "foo<BR/>bar<hr>"},
+	{"<a>foo<b x=1 />bar", "<a>foo<b x=1 />bar</a>"},
+
+	// "<>"
+}
+
+func TestBalanceHtmlTags(t *testing.T) {
+	for _, test := range balanceHtmlTagsExamples {
+		result := BalanceHtmlTags(test.before)
+		if test.expected != result {
+			t.Errorf("Expected '%v', got '%v'", test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package wikitext
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xmlEscaper escapes text content. Unlike xml.EscapeText it leaves new lines
+// alone so that the wikitext is still readable.
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// xmlAttributeEscaper also escapes whitespace that would otherwise be replaced
+// by a space when the attribute is read.
+var xmlAttributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;",
+	"\n", "&#10;", "\r", "&#13;", "\t", "&#9;")
+
+// xliffWriter creates an XLIFF 2.0 document. All markup is kept as inline
+// codes that refer to the original wikitext in <originalData>.
+type xliffWriter struct {
+	units    *bytes.Buffer
+	nextUnit int
+	nextData int
+
+	// These are reset for each unit.
+	data *bytes.Buffer
+	body *bytes.Buffer
+
+	// run is the content of the <segment> or <ignorable> that is being
+	// written.
+	run     *bytes.Buffer
+	runData *bytes.Buffer
+}
+
+// WikiToXliff converts wikitext into an XLIFF 2.0 document.
+func WikiToXliff(wikimarkup string) string {
+	return nodesToXliff(ParseWiki(wikimarkup), Options{}.source())
+}
+
+// nodesToXliff creates the XLIFF. The title of the source page is the original
+// of the <file>.
+func nodesToXliff(nodes []Node, source pageSource) string {
+	w := &xliffWriter{
+		units:   new(bytes.Buffer),
+		data:    new(bytes.Buffer),
+		body:    new(bytes.Buffer),
+		run:     new(bytes.Buffer),
+		runData: new(bytes.Buffer),
+	}
+
+	segmentNodes(nodes, w)
+
+	original := ""
+	if source.Title != "" {
+		original = ` original="` + xmlAttributeEscaper.Replace(source.Title) + `"`
+	}
+
+	return xml.Header +
+		`<xliff xmlns="urn:oasis:names:tc:xliff:document:2.0" version="2.0" srcLang="` +
+		xmlAttributeEscaper.Replace(source.Language) + `">` + "\n" +
+		`<file id="f1"` + original + ` xml:space="preserve">` + "\n" +
+		w.units.String() +
+		"</file>\n</xliff>\n"
+}
+
+func (w *xliffWriter) writeText(s string) {
+	xmlEscaper.WriteString(w.run, s)
+}
+
+func (w *xliffWriter) writePlaceholder(id int, code inlineCode) {
+	fmt.Fprintf(w.run, `<ph id="%d"%v dataRef="%v"`, id, xliffCodeType(code.Kind), w.addData(code.Start))
+	if code.Note != "" {
+		fmt.Fprintf(w.run, ` disp="%v"`, xmlAttributeEscaper.Replace(code.Note))
+	}
+	w.run.WriteString("/>")
+}
+
+func (w *xliffWriter) writeStartCode(id int, code inlineCode) {
+	fmt.Fprintf(w.run, `<pc id="%d"%v`, id, xliffCodeType(code.Kind))
+	if code.Start != "" {
+		fmt.Fprintf(w.run, ` dataRefStart="%v"`, w.addData(code.Start))
+	}
+	if code.End != "" {
+		fmt.Fprintf(w.run, ` dataRefEnd="%v"`, w.addData(code.End))
+	}
+	w.run.WriteString(">")
+}
+
+func (w *xliffWriter) writeEndCode(id int, code inlineCode) {
+	w.run.WriteString("</pc>")
+}
+
+func (w *xliffWriter) writeStartSpan(id int, code inlineCode) {
+	fmt.Fprintf(w.run, `<sc id="%d"%v`, id, xliffCodeType(code.Kind))
+	if code.Start != "" {
+		fmt.Fprintf(w.run, ` dataRef="%v"`, w.addData(code.Start))
+	}
+	w.run.WriteString("/>")
+}
+
+func (w *xliffWriter) writeEndSpan(id int, code inlineCode) {
+	fmt.Fprintf(w.run, `<ec startRef="%d"`, id)
+	if code.End != "" {
+		fmt.Fprintf(w.run, ` dataRef="%v"`, w.addData(code.End))
+	}
+	w.run.WriteString("/>")
+}
+
+func (w *xliffWriter) addData(s string) string {
+	w.nextData++
+	id := fmt.Sprintf("d%d", w.nextData)
+
+	fmt.Fprintf(w.runData, `<data id="%v">`, id)
+	xmlEscaper.WriteString(w.runData, s)
+	w.runData.WriteString("</data>\n")
+
+	return id
+}
+
+func (w *xliffWriter) endRun(translatable bool) {
+	element := "ignorable"
+	if translatable {
+		element = "segment"
+	}
+
+	fmt.Fprintf(w.body, "<%v>\n<source>%v</source>\n</%v>\n", element, w.run.String(), element)
+	w.data.Write(w.runData.Bytes())
+	w.run.Reset()
+	w.runData.Reset()
+}
+
+func (w *xliffWriter) endUnit() {
+	if w.body.Len() == 0 {
+		return
+	}
+
+	w.nextUnit++
+	fmt.Fprintf(w.units, "<unit id=\"u%d\">\n", w.nextUnit)
+	if w.data.Len() > 0 {
+		fmt.Fprintf(w.units, "<originalData>\n%v</originalData>\n", w.data.String())
+	}
+	w.units.WriteString(w.body.String() + "</unit>\n")
+
+	w.data.Reset()
+	w.body.Reset()
+}
+
+// xliffCodeType returns the type and subType attributes for a kind of code.
+// Bold and italic have their own subTypes in the XLIFF specification,
+// everything else uses our own "wt" prefix.
+func xliffCodeType(kind string) string {
+	switch kind {
+	case "bold":
+		return ` type="fmt" subType="xlf:b"`
+	case "italic":
+		return ` type="fmt" subType="xlf:i"`
+	case "link", "image":
+		return fmt.Sprintf(` type="%v" subType="wt:%v"`, kind, kind)
+	}
+
+	return fmt.Sprintf(` type="other" subType="wt:%v"`, kind)
+}
+
+func xliffCodeKind(subType string) string {
+	switch subType {
+	case "xlf:b":
+		return "bold"
+	case "xlf:i":
+		return "italic"
+	}
+
+	return strings.TrimPrefix(subType, "wt:")
+}
+
+// xliffReader rebuilds the document tree from an XLIFF 2.0 document. The
+// <target> is used where there is one, otherwise the <source>.
+type xliffReader struct {
+	decoder *xml.Decoder
+	data    map[string]string
+	builder *treeBuilder
+}
+
+// XliffToWiki converts a (translated) XLIFF 2.0 document created by
+// WikiToXliff back into wikitext.
+func XliffToWiki(xliff string) (string, error) {
+	wiki := new(bytes.Buffer)
+	if err := (&Converter{}).FromXLIFF(strings.NewReader(xliff), wiki, Options{}); err != nil {
+		return "", err
+	}
+
+	return wiki.String(), nil
+}
+
+func xliffToNodes(xliff string, opaqueTags []string) ([]Node, pageSource, error) {
+	r := &xliffReader{
+		decoder: xml.NewDecoder(strings.NewReader(xliff)),
+		builder: newTreeBuilder(opaqueTags),
+	}
+	language, title := "", ""
+
+	for {
+		token, err := r.decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, pageSource{}, invalidXliff(xliff, r.decoder, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "xliff":
+			language = xmlAttribute(start, "srcLang")
+
+		case "file":
+			title = xmlAttribute(start, "original")
+
+		case "unit":
+			r.data = map[string]string{}
+
+		case "data":
+			var data struct {
+				Value string `xml:",chardata"`
+			}
+			if err := r.decoder.DecodeElement(&data, &start); err != nil {
+				return nil, pageSource{}, invalidXliff(xliff, r.decoder, err)
+			}
+			r.data[xmlAttribute(start, "id")] = data.Value
+
+		case "segment", "ignorable":
+			tokens, err := readXliffSegment(r.decoder, start.Name.Local)
+			if err != nil {
+				return nil, pageSource{}, invalidXliff(xliff, r.decoder, err)
+			}
+
+			for _, token := range tokens {
+				if err := r.readInline(token); err != nil {
+					return nil, pageSource{}, invalidXliff(xliff, r.decoder, err)
+				}
+			}
+			if r.builder.err != nil {
+				return nil, pageSource{}, invalidXliff(xliff, r.decoder, r.builder.err)
+			}
+		}
+	}
+
+	nodes, err := r.builder.nodes()
+	if err != nil {
+		return nil, pageSource{}, invalidXliff(xliff, r.decoder, err)
+	}
+
+	return nodes, newPageSource(language, title), nil
+}
+
+// invalidXliff returns an ErrInvalidXliff for the current position of the
+// decoder.
+func invalidXliff(xliff string, decoder *xml.Decoder, err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return newError(ErrInvalidXliff, xliff, int(decoder.InputOffset()), "", err)
+}
+
+func xmlAttribute(start xml.StartElement, name string) string {
+	for _, attribute := range start.Attr {
+		if attribute.Name.Local == name {
+			return attribute.Value
+		}
+	}
+
+	return ""
+}
+
+// readXliffSegment collects the <source> and <target> of a segment and returns
+// the content of the one that should be used.
+func readXliffSegment(decoder *xml.Decoder, element string) ([]xml.Token, error) {
+	var source, target []xml.Token
+	var current *[]xml.Token
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "source":
+				current = &source
+				continue
+			case "target":
+				current = &target
+				continue
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "source", "target":
+				current = nil
+				continue
+			case element:
+				if target == nil {
+					return source, nil
+				}
+				return target, nil
+			}
+		}
+
+		if current != nil {
+			*current = append(*current, xml.CopyToken(token))
+		}
+	}
+}
+
+// code returns the code for a <pc>, <sc> or <ph>. The end of the code is not
+// needed to rebuild the node.
+func (r *xliffReader) code(start xml.StartElement, dataRef string) (inlineCode, error) {
+	id := xmlAttribute(start, dataRef)
+	data, ok := r.data[id]
+
+	// A paired code does not have a dataRef if it does not start with any
+	// wikitext.
+	if !ok && (id != "" || start.Name.Local == "ph") {
+		return inlineCode{}, fmt.Errorf("<%v> refers to missing data %q", start.Name.Local, id)
+	}
+
+	code := inlineCode{
+		Kind:  xliffCodeKind(xmlAttribute(start, "subType")),
+		Start: data,
+	}
+
+	// Bold and italic only have no end when the end of the line closed them.
+	if start.Name.Local == "pc" && (code.Kind == "bold" || code.Kind == "italic") {
+		code.Unclosed = xmlAttribute(start, "dataRefEnd") == ""
+	}
+
+	return code, nil
+}
+
+func (r *xliffReader) readInline(token xml.Token) error {
+	switch t := token.(type) {
+	case xml.CharData:
+		r.builder.text(string(t))
+
+	case xml.StartElement:
+		switch t.Name.Local {
+		case "pc", "sc", "ph":
+			dataRef := "dataRef"
+			if t.Name.Local == "pc" {
+				dataRef = "dataRefStart"
+			}
+
+			code, err := r.code(t, dataRef)
+			if err != nil {
+				return err
+			}
+
+			if t.Name.Local == "ph" {
+				r.builder.placeholder(code)
+			} else {
+				r.builder.open(xmlAttribute(t, "id"), code)
+			}
+
+		case "ec":
+			r.builder.close(xmlAttribute(t, "startRef"))
+		}
+
+	case xml.EndElement:
+		if t.Name.Local == "pc" {
+			r.builder.pop()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,367 @@
+package wikitext
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xliff12Writer creates an XLIFF 1.2 document and its skeleton. Each segment
+// becomes a <trans-unit>. Everything else, including the wikitext for every
+// code, is kept in the skeleton so that it is never shown to translators.
+//
+// The skeleton is our own XML format. <codes> has the wikitext for each code
+// and <body> is the order in which the text, codes and trans-units have to be
+// put back together.
+type xliff12Writer struct {
+	transUnits    *bytes.Buffer
+	codes         *bytes.Buffer
+	skeleton      *bytes.Buffer
+	nextTransUnit int
+
+	// The content of the current run is written to both the trans-unit and
+	// skeleton, only one of them will be kept when the run ends.
+	run         *bytes.Buffer
+	runSkeleton *bytes.Buffer
+}
+
+// WikiToXliff12 converts wikitext into an XLIFF 1.2 document and the skeleton
+// that is needed to convert it back. The XLIFF refers to the skeleton as
+// name.skl.
+func WikiToXliff12(wikimarkup, name string) (xliff, skeleton string) {
+	return nodesToXliff12(ParseWiki(wikimarkup), name, Options{}.source())
+}
+
+// nodesToXliff12 creates the XLIFF and skeleton. The title of the source page
+// is kept in the skeleton because the original of the <file> is the name of
+// the skeleton.
+func nodesToXliff12(nodes []Node, name string, source pageSource) (xliff, skeleton string) {
+	w := &xliff12Writer{
+		transUnits:  new(bytes.Buffer),
+		codes:       new(bytes.Buffer),
+		skeleton:    new(bytes.Buffer),
+		run:         new(bytes.Buffer),
+		runSkeleton: new(bytes.Buffer),
+	}
+
+	segmentNodes(nodes, w)
+
+	xliff = xml.Header +
+		`<xliff xmlns="urn:oasis:names:tc:xliff:document:1.2" version="1.2">` + "\n" +
+		`<file original="` + xmlAttributeEscaper.Replace(name) + `" source-language="` +
+		xmlAttributeEscaper.Replace(source.Language) + `" datatype="x-wikitext">` + "\n" +
+		"<header>\n<skl>\n" +
+		`<external-file href="` + xmlAttributeEscaper.Replace(name) + `.skl"/>` + "\n" +
+		"</skl>\n</header>\n<body>\n" +
+		w.transUnits.String() +
+		"</body>\n</file>\n</xliff>\n"
+
+	title := ""
+	if source.Title != "" {
+		title = ` title="` + xmlAttributeEscaper.Replace(source.Title) + `"`
+	}
+
+	skeleton = xml.Header +
+		"<skeleton" + title + ">\n<codes>\n" +
+		w.codes.String() +
+		"</codes>\n<body>\n" +
+		w.skeleton.String() +
+		"</body>\n</skeleton>\n"
+
+	return
+}
+
+// xliff12CodeType returns the ctype for a kind of code.
+func xliff12CodeType(kind string) string {
+	switch kind {
+	case "bold", "italic", "link", "image":
+		return kind
+	}
+
+	return "x-wt-" + kind
+}
+
+// xliff12VisibleCode returns the wikitext that will be shown to translators
+// inside a code. The content of references, nowiki, opaque tags, templates,
+// hidden arguments, image options and magic words is hidden. Only the Note is
+// shown for comments and the attributes of tables are hidden.
+func xliff12VisibleCode(code inlineCode, s string) string {
+	switch {
+	case s == code.Start && s != "" && (code.Kind == "table" || code.Kind == "row" || code.Kind == "caption"):
+		return s[:2]
+
+	case s == code.Start && s != "" && code.Kind == "cell":
+		if strings.HasPrefix(s, "||") || strings.HasPrefix(s, "!!") {
+			return s[:2]
+		}
+		return s[:1]
+
+	case code.Kind == "comment":
+		return xmlEscaper.Replace(code.Note)
+
+	case code.Kind == "ref", code.Kind == "nowiki", code.Kind == "tag", code.Kind == "template", code.Kind == "function",
+		code.Kind == "arg" && !code.Paired, code.Kind == "magic" && !code.Paired,
+		code.Kind == "option" && !code.Paired:
+		return ""
+	}
+
+	return xmlEscaper.Replace(s)
+}
+
+func (w *xliff12Writer) addCode(id int, code inlineCode) {
+	unclosed := ""
+	if code.Unclosed {
+		unclosed = ` unclosed="true"`
+	}
+	fmt.Fprintf(w.codes, `<code id="%d" kind="%v"%v>`, id, code.Kind, unclosed)
+	xmlEscaper.WriteString(w.codes, code.Start)
+	w.codes.WriteString("</code>\n")
+}
+
+func (w *xliff12Writer) writeText(s string) {
+	xmlEscaper.WriteString(w.run, s)
+
+	w.runSkeleton.WriteString("<text>")
+	xmlEscaper.WriteString(w.runSkeleton, s)
+	w.runSkeleton.WriteString("</text>\n")
+}
+
+func (w *xliff12Writer) writePlaceholder(id int, code inlineCode) {
+	w.addCode(id, code)
+	fmt.Fprintf(w.run, `<ph id="%d" ctype="%v">%v</ph>`,
+		id, xliff12CodeType(code.Kind), xliff12VisibleCode(code, code.Start))
+	fmt.Fprintf(w.runSkeleton, "<placeholder id=\"%d\"/>\n", id)
+}
+
+func (w *xliff12Writer) writeStartCode(id int, code inlineCode) {
+	w.addCode(id, code)
+	fmt.Fprintf(w.run, `<bpt id="%d" ctype="%v">%v</bpt>`,
+		id, xliff12CodeType(code.Kind), xliff12VisibleCode(code, code.Start))
+	fmt.Fprintf(w.runSkeleton, "<open id=\"%d\"/>\n", id)
+}
+
+func (w *xliff12Writer) writeEndCode(id int, code inlineCode) {
+	fmt.Fprintf(w.run, `<ept id="%d">%v</ept>`, id, xliff12VisibleCode(code, code.End))
+	fmt.Fprintf(w.runSkeleton, "<close id=\"%d\"/>\n", id)
+}
+
+func (w *xliff12Writer) writeStartSpan(id int, code inlineCode) {
+	w.addCode(id, code)
+	fmt.Fprintf(w.run, `<it id="%d" pos="open" ctype="%v">%v</it>`,
+		id, xliff12CodeType(code.Kind), xliff12VisibleCode(code, code.Start))
+	fmt.Fprintf(w.runSkeleton, "<open id=\"%d\"/>\n", id)
+}
+
+func (w *xliff12Writer) writeEndSpan(id int, code inlineCode) {
+	fmt.Fprintf(w.run, `<it id="%d" pos="close" ctype="%v">%v</it>`,
+		id, xliff12CodeType(code.Kind), xliff12VisibleCode(code, code.End))
+	fmt.Fprintf(w.runSkeleton, "<close id=\"%d\"/>\n", id)
+}
+
+func (w *xliff12Writer) endRun(translatable bool) {
+	if translatable {
+		w.nextTransUnit++
+		fmt.Fprintf(w.transUnits, "<trans-unit id=\"%d\" xml:space=\"preserve\">\n<source>%v</source>\n</trans-unit>\n",
+			w.nextTransUnit, w.run.String())
+		fmt.Fprintf(w.skeleton, "<trans-unit id=\"%d\"/>\n", w.nextTransUnit)
+	} else {
+		w.skeleton.Write(w.runSkeleton.Bytes())
+	}
+
+	w.run.Reset()
+	w.runSkeleton.Reset()
+}
+
+func (w *xliff12Writer) endUnit() {
+}
+
+// Xliff12SkeletonHref returns the location of the skeleton that an XLIFF 1.2
+// document refers to. It will be empty if the document is not XLIFF 1.2.
+func Xliff12SkeletonHref(xliff string) string {
+	decoder := xml.NewDecoder(strings.NewReader(xliff))
+	isXliff12 := false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			switch start.Name.Local {
+			case "xliff":
+				isXliff12 = xmlAttribute(start, "version") == "1.2"
+
+			case "external-file":
+				if isXliff12 {
+					return xmlAttribute(start, "href")
+				}
+			}
+		}
+	}
+}
+
+// Xliff12ToWiki converts a (translated) XLIFF 1.2 document and the skeleton
+// created by WikiToXliff12 back into wikitext.
+func Xliff12ToWiki(xliff, skeleton string) (string, error) {
+	wiki := new(bytes.Buffer)
+	err := (&Converter{}).FromXLIFF12(strings.NewReader(xliff), strings.NewReader(skeleton), wiki, Options{})
+	if err != nil {
+		return "", err
+	}
+
+	return wiki.String(), nil
+}
+
+func xliff12ToNodes(xliff, skeleton string, opaqueTags []string) ([]Node, pageSource, error) {
+	// Collect the content of each trans-unit.
+	language, title := "", ""
+	transUnits := map[string][]xml.Token{}
+	decoder := xml.NewDecoder(strings.NewReader(xliff))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, pageSource{}, invalidXliff(xliff, decoder, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "file":
+			language = xmlAttribute(start, "source-language")
+
+		case "trans-unit":
+			tokens, err := readXliffSegment(decoder, "trans-unit")
+			if err != nil {
+				return nil, pageSource{}, invalidXliff(xliff, decoder, err)
+			}
+			transUnits[xmlAttribute(start, "id")] = tokens
+		}
+	}
+
+	// Put everything back together in the order of the skeleton.
+	codes := map[string]inlineCode{}
+	builder := newTreeBuilder(opaqueTags)
+	decoder = xml.NewDecoder(strings.NewReader(skeleton))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, pageSource{}, invalidXliff(skeleton, decoder, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		id := xmlAttribute(start, "id")
+		switch start.Name.Local {
+		case "skeleton":
+			title = xmlAttribute(start, "title")
+
+		case "code":
+			var code struct {
+				Value string `xml:",chardata"`
+			}
+			if err := decoder.DecodeElement(&code, &start); err != nil {
+				return nil, pageSource{}, invalidXliff(skeleton, decoder, err)
+			}
+			codes[id] = inlineCode{
+				Kind:     xmlAttribute(start, "kind"),
+				Start:    code.Value,
+				Unclosed: xmlAttribute(start, "unclosed") == "true",
+			}
+
+		case "text":
+			var text struct {
+				Value string `xml:",chardata"`
+			}
+			if err := decoder.DecodeElement(&text, &start); err != nil {
+				return nil, pageSource{}, invalidXliff(skeleton, decoder, err)
+			}
+			builder.text(text.Value)
+
+		case "placeholder":
+			builder.placeholder(codes[id])
+
+		case "open":
+			builder.open(id, codes[id])
+
+		case "close":
+			builder.close(id)
+
+		case "trans-unit":
+			readXliff12TransUnit(builder, codes, transUnits[id])
+		}
+
+		if builder.err != nil {
+			return nil, pageSource{}, invalidXliff(skeleton, decoder, builder.err)
+		}
+	}
+
+	nodes, err := builder.nodes()
+	if err != nil {
+		return nil, pageSource{}, invalidXliff(skeleton, decoder, err)
+	}
+
+	return nodes, newPageSource(language, title), nil
+}
+
+// readXliff12TransUnit adds the content of a trans-unit. The content of the
+// codes is ignored because the skeleton has the original wikitext.
+func readXliff12TransUnit(builder *treeBuilder, codes map[string]inlineCode, tokens []xml.Token) {
+	depth := 0
+
+	for _, token := range tokens {
+		switch t := token.(type) {
+		case xml.CharData:
+			if depth == 0 {
+				builder.text(string(t))
+			}
+
+		case xml.StartElement:
+			depth++
+			id := xmlAttribute(t, "id")
+
+			switch t.Name.Local {
+			case "bpt":
+				builder.open(id, codes[id])
+
+			case "ept":
+				builder.close(id)
+
+			case "ph":
+				builder.placeholder(codes[id])
+
+			case "it":
+				if xmlAttribute(t, "pos") == "open" {
+					builder.open(id, codes[id])
+				} else {
+					builder.close(id)
+				}
+
+			default:
+				// Markup added by the CAT tool, such as <mrk>, may contain
+				// text.
+				depth--
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "bpt", "ept", "ph", "it":
+				depth--
+			}
+		}
+	}
+}
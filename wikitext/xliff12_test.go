@@ -0,0 +1,84 @@
+package wikitext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXliff12RoundTrip(t *testing.T) {
+	for _, test := range examples {
+		expected := roundTrip(t, test.wiki)
+
+		xliff, skeleton := WikiToXliff12(test.wiki, "Foo")
+		wiki, err := Xliff12ToWiki(xliff, skeleton)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if wiki != expected {
+			t.Errorf("%v:\n  expected wiki: '%v'\n     from XLIFF: '%v'\n       skeleton: '%v'\n            got: '%v'\n\n",
+				test.name, expected, xliff, skeleton, wiki)
+		}
+	}
+}
+
+func TestWikiToXliff12(t *testing.T) {
+	xliff, skeleton := WikiToXliff12("foo '''bar''' {{baz|a=qux}}<ref>x</ref>\n\n{{qux}}", "Foo")
+
+	for _, expected := range []string{
+		`<external-file href="Foo.skl"/>`,
+		`<trans-unit id="1" xml:space="preserve">
+<source>foo <bpt id="1" ctype="bold">'''</bpt>bar<ept id="1">'''</ept> <bpt id="2" ctype="x-wt-template"></bpt><bpt id="3" ctype="x-wt-arg">|a=</bpt>qux<ept id="3"></ept><ept id="2"></ept><ph id="4" ctype="x-wt-ref"></ph></source>
+</trans-unit>`,
+	} {
+		if !strings.Contains(xliff, expected) {
+			t.Errorf("Expected:\n%v\nto contain:\n%v", xliff, expected)
+		}
+	}
+
+	// The hidden parts are only in the skeleton.
+	for _, expected := range []string{
+		`<code id="2" kind="template">{{baz</code>`,
+		`<code id="4" kind="ref">&lt;ref&gt;x&lt;/ref&gt;</code>`,
+		"<trans-unit id=\"1\"/>\n<text>\n</text>\n<text>\n</text>\n<placeholder id=\"5\"/>\n",
+	} {
+		if !strings.Contains(skeleton, expected) {
+			t.Errorf("Expected:\n%v\nto contain:\n%v", skeleton, expected)
+		}
+	}
+}
+
+func TestXliff12ToWikiUsesTarget(t *testing.T) {
+	xliff, skeleton := WikiToXliff12("foo [[Bar|baz]]", "Foo")
+	xliff = strings.Replace(xliff, "</source>",
+		`</source><target>le <mrk mtype="x-note"><bpt id="1" ctype="link">[[Bar|</bpt>baz traduit<ept id="1">]]</ept></mrk></target>`, 1)
+
+	expected := "le [[Bar|baz traduit]]"
+	if wiki, err := Xliff12ToWiki(xliff, skeleton); err != nil || wiki != expected {
+		t.Errorf("Expected '%v', got '%v' (%v)", expected, wiki, err)
+	}
+
+	if href := Xliff12SkeletonHref(xliff); href != "Foo.skl" {
+		t.Errorf("Expected 'Foo.skl', got '%v'", href)
+	}
+
+	if href := Xliff12SkeletonHref(WikiToXliff("foo")); href != "" {
+		t.Errorf("Expected '', got '%v'", href)
+	}
+}
+
+func TestWikiToXliff12HidesTableAttributes(t *testing.T) {
+	xliff, skeleton := WikiToXliff12("{| class=\"a\"\n|+ b=c | Cap\n|- d\n! e | f\n|}", "Foo")
+
+	for _, expected := range []string{
+		`<source><bpt id="2" ctype="x-wt-caption">|+</bpt> Cap<ept id="2"></ept></source>`,
+		`<source><bpt id="4" ctype="x-wt-cell">!</bpt> f<ept id="4"></ept></source>`,
+	} {
+		if !strings.Contains(xliff, expected) {
+			t.Errorf("Expected:\n%v\nto contain:\n%v", xliff, expected)
+		}
+	}
+
+	if !strings.Contains(skeleton, `<code id="4" kind="cell">! e |</code>`) {
+		t.Errorf("Expected the attributes in the skeleton:\n%v", skeleton)
+	}
+}
@@ -0,0 +1,73 @@
+package wikitext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXliffRoundTrip(t *testing.T) {
+	for _, test := range examples {
+		expected := roundTrip(t, test.wiki)
+
+		xliff := WikiToXliff(test.wiki)
+		wiki, err := XliffToWiki(xliff)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if wiki != expected {
+			t.Errorf("%v:\n  expected wiki: '%v'\n     from XLIFF: '%v'\n            got: '%v'\n\n",
+				test.name, expected, xliff, wiki)
+		}
+	}
+}
+
+func TestWikiToXliff(t *testing.T) {
+	xliff := WikiToXliff("foo '''bar''' {{baz|a=qux}}<ref>x</ref>\n== The Heading ==")
+	expected := `<unit id="u1">
+<originalData>
+<data id="d1">'''</data>
+<data id="d2">'''</data>
+<data id="d3">{{baz</data>
+<data id="d4">}}</data>
+<data id="d5">|a=</data>
+<data id="d6">&lt;ref&gt;x&lt;/ref&gt;</data>
+</originalData>
+<segment>
+<source>foo <pc id="1" type="fmt" subType="xlf:b" dataRefStart="d1" dataRefEnd="d2">bar</pc> <pc id="2" type="other" subType="wt:template" dataRefStart="d3" dataRefEnd="d4"><pc id="3" type="other" subType="wt:arg" dataRefStart="d5">qux</pc></pc><ph id="4" type="other" subType="wt:ref" dataRef="d6"/></source>
+</segment>
+<ignorable>
+<source>
+</source>
+</ignorable>
+</unit>
+<unit id="u2">
+<originalData>
+<data id="d7">==</data>
+<data id="d8">==</data>
+</originalData>
+<segment>
+<source><pc id="5" type="other" subType="wt:heading" dataRefStart="d7" dataRefEnd="d8"> The Heading </pc></source>
+</segment>
+</unit>
+`
+
+	if !strings.Contains(xliff, expected) {
+		t.Errorf("Expected:\n%v\nto contain:\n%v", xliff, expected)
+	}
+}
+
+func TestXliffToWikiUsesTarget(t *testing.T) {
+	xliff := WikiToXliff("foo ''bar''\n{|\n|-\n|Bar\n|}")
+	xliff = strings.Replace(xliff,
+		`<source>foo <pc id="1" type="fmt" subType="xlf:i" dataRefStart="d1" dataRefEnd="d2">bar</pc></source>`,
+		`<source>foo <pc id="1" type="fmt" subType="xlf:i" dataRefStart="d1" dataRefEnd="d2">bar</pc></source>
+<target>le <pc id="1" type="fmt" subType="xlf:i" dataRefStart="d1" dataRefEnd="d2">bar</pc> traduit</target>`, 1)
+	xliff = strings.Replace(xliff,
+		`>Bar</pc></source>`,
+		`>Bar</pc></source><target><pc id="4" type="other" subType="wt:cell" dataRefStart="d5">Barre</pc></target>`, 1)
+
+	expected := "le ''bar'' traduit\n{|\n|-\n|Barre\n|}"
+	if wiki, err := XliffToWiki(xliff); err != nil || wiki != expected {
+		t.Errorf("Expected '%v', got '%v' (%v)\n%v", expected, wiki, err, xliff)
+	}
+}